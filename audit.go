@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The default maximum size in bytes of a diff in the audit log.
+const DefaultAuditMaxDiff = 64 * 1024
+
+// A change made to a template destination.
+type AuditChange struct {
+	Dest      string `json:"dest"`
+	OldHash   string `json:"old_hash"`
+	NewHash   string `json:"new_hash"`
+	Diff      string `json:"diff,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// A record of an execution in the audit log.
+type AuditRecord struct {
+	Time     time.Time     `json:"time"`
+	Watcher  string        `json:"watcher"`
+	Key      string        `json:"key,omitempty"`
+	Index    uint64        `json:"index,omitempty"`
+	Changes  []AuditChange `json:"changes"`
+	Command  []string      `json:"command,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Duration float64       `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	audit    *AuditLog
+	diff     bool
+}
+
+// An append-only log of executions. Each record is written as a line of JSON.
+type AuditLog struct {
+	lock    sync.Mutex
+	writer  io.Writer
+	MaxDiff int
+}
+
+// Create an audit log which writes to `writer`.
+func NewAuditLog(writer io.Writer) *AuditLog {
+	return &AuditLog{writer: writer, MaxDiff: DefaultAuditMaxDiff}
+}
+
+// Open the audit log configured in `config`. Return nil if no path is
+// configured.
+func ConfigAuditLog(config *settings.Settings) (*AuditLog, error) {
+	path := config.StringDflt("path", "")
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("audit log '%s' could not be opened: %s", path, err)
+	}
+	audit := NewAuditLog(file)
+	audit.MaxDiff = config.IntDflt("max-diff", DefaultAuditMaxDiff)
+	return audit, nil
+}
+
+// Create a record for an execution of `watcher` triggered by `event`. The
+// event is nil if the execution was not triggered by a change. Changes include
+// diffs if `diff` is true.
+func (a *AuditLog) Record(watcher string, event *Event, diff bool) *AuditRecord {
+	record := &AuditRecord{
+		Time:    time.Now(),
+		Watcher: watcher,
+		Changes: []AuditChange{},
+		audit:   a,
+		diff:    diff,
+	}
+	if event != nil {
+		record.Key = event.Key
+		record.Index = event.Index
+	}
+	return record
+}
+
+// Create a change record for `dest` from its contents `before` and `after`
+// the change. The old hash is empty if the file did not exist. A diff is only
+// included if `diff` is true. It is truncated to the maximum size.
+func (a *AuditLog) Change(dest string, before, after []byte, diff bool) AuditChange {
+	change := AuditChange{
+		Dest:    dest,
+		NewHash: fmt.Sprintf("%x", sha256.Sum256(after)),
+	}
+	if before != nil {
+		change.OldHash = fmt.Sprintf("%x", sha256.Sum256(before))
+	}
+	if !diff {
+		return change
+	}
+	change.Diff = logger.Redact(UnifiedDiff(dest, dest, string(before), string(after))).(string)
+	if a.MaxDiff >= 0 && len(change.Diff) > a.MaxDiff {
+		change.Diff = change.Diff[:a.MaxDiff]
+		change.Truncated = true
+	}
+	return change
+}
+
+// Add a change to `dest` to the record.
+func (r *AuditRecord) addChange(dest string, before, after []byte) {
+	r.Changes = append(r.Changes, r.audit.Change(dest, before, after, r.diff))
+}
+
+// Append a record to the log.
+func (a *AuditLog) Write(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.writer.Write(append(data, '\n'))
+	return err
+}
+
+// Close the underlying writer if it can be closed.
+func (a *AuditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if closer, ok := a.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// The audit log written by executors. Auditing is disabled when nil.
+var auditLog *AuditLog
+
+// Guards the audit log.
+var auditLock sync.RWMutex
+
+// Replace the audit log. The previous log is closed.
+func setAuditLog(audit *AuditLog) {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditLog != nil {
+		auditLog.Close()
+	}
+	auditLog = audit
+}
+
+// Return the current audit log or nil if auditing is disabled.
+func getAuditLog() *AuditLog {
+	auditLock.RLock()
+	defer auditLock.RUnlock()
+	return auditLog
+}
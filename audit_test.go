@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	tc := NewExecutorTestCase(t)
+	defer tc.Close()
+
+	out := &bytes.Buffer{}
+	setAuditLog(NewAuditLog(out))
+	defer setAuditLog(nil)
+
+	exec := TemplateExecutor{
+		name:      "test",
+		prefix:    "sentinel",
+		context:   []string{"sentinel/context_a"},
+		Templates: []Template{tc.Template},
+		Command:   []string{"bash", "-c", "exit 2"},
+		AuditDiff: true,
+	}
+	if err := ioutil.WriteFile(tc.Template.Src, []byte("value={{.context_a.value}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tc.Template.Dest, []byte("value=z\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &Event{Prefix: "sentinel", Key: "sentinel/context_a/value", Action: "set", Index: 7}
+	if err := exec.Execute(tc.Client, event); err == nil {
+		t.Error("command did not fail")
+	}
+	exec.Execute(tc.Client, nil)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected records: %q", lines)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Watcher != "test" || record.Key != event.Key || record.Index != 7 {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	if !reflect.DeepEqual(exec.Command, record.Command) || record.ExitCode == nil || *record.ExitCode != 2 {
+		t.Errorf("unexpected command: %+v", record)
+	}
+	if len(record.Changes) != 1 {
+		t.Fatalf("unexpected changes: %+v", record.Changes)
+	}
+	change := record.Changes[0]
+	if change.Dest != tc.Template.Dest || change.OldHash == "" || change.OldHash == change.NewHash {
+		t.Errorf("unexpected change: %+v", change)
+	}
+	if !strings.Contains(change.Diff, "-value=z\n+value=a\n") {
+		t.Errorf("unexpected diff: %s", change.Diff)
+	}
+
+	record = AuditRecord{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != "" || len(record.Changes) != 0 || record.Command != nil {
+		t.Errorf("unexpected record: %+v", record)
+	}
+}
+
+func TestAuditLogTruncate(t *testing.T) {
+	audit := NewAuditLog(&bytes.Buffer{})
+	audit.MaxDiff = 10
+	change := audit.Change("dest", nil, []byte("a long line of text\n"), true)
+	if len(change.Diff) != 10 || !change.Truncated || change.OldHash != "" {
+		t.Errorf("unexpected change: %+v", change)
+	}
+}
+
+func TestAuditLogNoDiff(t *testing.T) {
+	audit := NewAuditLog(&bytes.Buffer{})
+	change := audit.Change("dest", []byte("password=old\n"), []byte("password=new\n"), false)
+	if change.Diff != "" || change.OldHash == "" || change.NewHash == "" {
+		t.Errorf("unexpected change: %+v", change)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The type of a config value.
+type configType int
+
+const (
+	typeString configType = iota
+	typeStrings
+	typeBool
+	typeInt
+	typeDuration
+	typeObject
+	typeMap
+	typeList
+)
+
+// Describes the expected structure of a config value. Objects have a fixed set
+// of `Fields`. Maps have arbitrary keys whose values are described by `Elem`.
+// Lists contain values described by `Elem`. Environment variable references
+// are not expanded in `Raw` values.
+type configSchema struct {
+	Type     configType
+	Fields   map[string]*configSchema
+	Required []string
+	Elem     *configSchema
+	Raw      bool
+}
+
+// Return true if values described by the schema contain other values.
+func (s *configSchema) container() bool {
+	return s.Type == typeObject || s.Type == typeMap || s.Type == typeList
+}
+
+var (
+	stringSchema   = &configSchema{Type: typeString}
+	stringsSchema  = &configSchema{Type: typeStrings}
+	boolSchema     = &configSchema{Type: typeBool}
+	intSchema      = &configSchema{Type: typeInt}
+	durationSchema = &configSchema{Type: typeDuration}
+
+	templateSchema = &configSchema{
+		Type: typeObject,
+		Fields: map[string]*configSchema{
+			"src":  stringSchema,
+			"dest": stringSchema,
+		},
+		Required: []string{"src", "dest"},
+	}
+
+	watcherSchema = &configSchema{
+		Type: typeObject,
+		Fields: map[string]*configSchema{
+			"prefix":    stringSchema,
+			"watch":     stringsSchema,
+			"context":   stringsSchema,
+			"templates": {Type: typeList, Elem: templateSchema},
+			"command":   {Type: typeStrings, Raw: true},
+			"lock": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"key":  stringSchema,
+					"ttl":  durationSchema,
+					"wait": boolSchema,
+				},
+			},
+			"leader-only":      boolSchema,
+			"interval":         durationSchema,
+			"type":             stringSchema,
+			"env":              {Type: typeMap, Elem: stringSchema},
+			"dir":              stringSchema,
+			"stdin":            boolSchema,
+			"command-template": boolSchema,
+			"audit-diff":       boolSchema,
+			"user":             stringSchema,
+			"group":            stringSchema,
+			"signal": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"signal":       stringSchema,
+					"pidfile":      stringSchema,
+					"process-name": stringSchema,
+					"cgroup":       stringSchema,
+				},
+			},
+			"kv": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"key": stringSchema,
+					"src": stringSchema,
+					"ttl": durationSchema,
+				},
+				Required: []string{"key", "src"},
+			},
+			"webhook": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"url":         stringSchema,
+					"headers":     {Type: typeMap, Elem: stringSchema},
+					"secret":      stringSchema,
+					"timeout":     durationSchema,
+					"retries":     intSchema,
+					"retry-delay": durationSchema,
+					"context":     boolSchema,
+				},
+				Required: []string{"url"},
+			},
+		},
+	}
+
+	watchersSchema = &configSchema{Type: typeMap, Elem: watcherSchema}
+
+	// The schema of the main config file.
+	ConfigSchema = &configSchema{
+		Type: typeObject,
+		Fields: map[string]*configSchema{
+			"include":  stringsSchema,
+			"on-start": boolSchema,
+			"etcd": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"uri":         stringSchema,
+					"uris":        stringsSchema,
+					"prefix":      stringSchema,
+					"tls-key":     stringSchema,
+					"tls-cert":    stringSchema,
+					"tls-ca-cert": stringSchema,
+				},
+			},
+			"watchers": watchersSchema,
+			"http": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"listen":          stringSchema,
+					"backoff-timeout": durationSchema,
+				},
+			},
+			"leader": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"key": stringSchema,
+					"ttl": durationSchema,
+				},
+			},
+			"state": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"path": stringSchema,
+				},
+			},
+			"audit": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"path":     stringSchema,
+					"max-diff": intSchema,
+				},
+			},
+			"logging": {
+				Type: typeObject,
+				Fields: map[string]*configSchema{
+					"target": stringSchema,
+					"level":  stringSchema,
+					"format": stringSchema,
+					"redact": {
+						Type: typeObject,
+						Fields: map[string]*configSchema{
+							"defaults": boolSchema,
+							"keys":     stringsSchema,
+							"values":   {Type: typeStrings, Raw: true},
+						},
+					},
+				},
+			},
+		},
+		Required: []string{"watchers"},
+	}
+
+	// The schema of a drop-in config file.
+	IncludeSchema = &configSchema{
+		Type: typeObject,
+		Fields: map[string]*configSchema{
+			"watchers": watchersSchema,
+		},
+	}
+)
+
+// A problem found while checking the config. Warnings do not prevent Sentinel
+// from running.
+type ConfigProblem struct {
+	File    string
+	Path    string
+	Message string
+	Warning bool
+}
+
+func (p ConfigProblem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	location := p.Path
+	if p.File != "" {
+		location = fmt.Sprintf("%s: %s", p.File, p.Path)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, location, p.Message)
+}
+
+// Return the schema of the config value at `path` or nil if the path is not
+// described by the schema.
+func lookupSchema(path string) *configSchema {
+	schema := ConfigSchema
+	for _, key := range strings.Split(path, ".") {
+		switch schema.Type {
+		case typeObject:
+			schema = schema.Fields[key]
+		case typeMap, typeList:
+			schema = schema.Elem
+		default:
+			schema = nil
+		}
+		if schema == nil {
+			return nil
+		}
+	}
+	return schema
+}
+
+// Join a key to a config path.
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Return the keys of a raw config map in sorted order.
+func sortedConfigKeys(mapping map[interface{}]interface{}) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check a raw config `value` found at `path` against the `schema`. Return all
+// problems found.
+func CheckSchema(path string, value interface{}, schema *configSchema) []ConfigProblem {
+	problem := func(format string, a ...interface{}) []ConfigProblem {
+		return []ConfigProblem{{Path: path, Message: fmt.Sprintf(format, a...)}}
+	}
+
+	switch schema.Type {
+	case typeString:
+		if _, ok := value.(string); !ok {
+			return problem("must be a string")
+		}
+	case typeStrings:
+		switch value.(type) {
+		case string, []string:
+			return nil
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return problem("must be a string or a list of strings")
+		}
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return problem("must be a string or a list of strings")
+			}
+		}
+	case typeBool:
+		if _, ok := value.(bool); !ok {
+			return problem("must be true or false")
+		}
+	case typeInt:
+		if _, ok := value.(int); !ok {
+			return problem("must be an integer")
+		}
+	case typeDuration:
+		text, ok := value.(string)
+		if !ok {
+			return problem("must be a duration")
+		}
+		if _, err := time.ParseDuration(text); err != nil {
+			return problem("must be a duration: %s", err)
+		}
+	case typeObject:
+		mapping, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return problem("must be a mapping")
+		}
+		problems := []ConfigProblem{}
+		for _, key := range schema.Required {
+			if _, ok := mapping[key]; !ok {
+				problems = append(problems, ConfigProblem{Path: joinConfigPath(path, key), Message: "is missing"})
+			}
+		}
+		for _, key := range sortedConfigKeys(mapping) {
+			keyPath := joinConfigPath(path, key)
+			if fieldSchema, ok := schema.Fields[key]; ok {
+				problems = append(problems, CheckSchema(keyPath, mapping[key], fieldSchema)...)
+			} else {
+				problems = append(problems, ConfigProblem{Path: keyPath, Message: "unknown key", Warning: true})
+			}
+		}
+		return problems
+	case typeMap:
+		mapping, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return problem("must be a mapping")
+		}
+		problems := []ConfigProblem{}
+		for _, key := range sortedConfigKeys(mapping) {
+			problems = append(problems, CheckSchema(joinConfigPath(path, key), mapping[key], schema.Elem)...)
+		}
+		return problems
+	case typeList:
+		items, ok := value.([]interface{})
+		if !ok {
+			return problem("must be a list")
+		}
+		problems := []ConfigProblem{}
+		for n, item := range items {
+			problems = append(problems, CheckSchema(joinConfigPath(path, fmt.Sprint(n)), item, schema.Elem)...)
+		}
+		return problems
+	}
+	return nil
+}
+
+// Check the YAML file at `path` against the `schema`. Values are checked after
+// environment variables found with `lookup` are expanded and applied.
+func CheckFile(path string, schema *configSchema, lookup EnvLookup) []ConfigProblem {
+	var problems []ConfigProblem
+	var value interface{}
+	if data, err := ioutil.ReadFile(path); err != nil {
+		problems = []ConfigProblem{{Message: err.Error()}}
+	} else if err = yaml.Unmarshal(data, &value); err != nil {
+		problems = []ConfigProblem{{Message: err.Error()}}
+	} else {
+		if value == nil {
+			value = map[interface{}]interface{}{}
+		}
+		// check values as they are after interpolation and overrides
+		mapping, _ := value.(map[interface{}]interface{})
+		config := &settings.Settings{Values: mapping}
+		if err := configExpandEnv(config, schema, lookup); err != nil {
+			problems = []ConfigProblem{{Message: err.Error()}}
+		} else if err := configEnv(config, schema, lookup); err != nil {
+			problems = []ConfigProblem{{Message: err.Error()}}
+		} else {
+			problems = CheckSchema("", value, schema)
+		}
+	}
+	for n := range problems {
+		problems[n].File = path
+	}
+	return problems
+}
+
+// Check that the watchers in a loaded `config` can be run. Each watcher must
+// parse as it does when Sentinel starts, templates must exist and parse, and
+// commands must resolve to an executable.
+func CheckWatchers(config *settings.Settings) []ConfigProblem {
+	problems := []ConfigProblem{}
+	watchers := config.ObjectMapDflt("watchers", map[string]*settings.Settings{})
+	if len(watchers) == 0 {
+		problems = append(problems, ConfigProblem{Path: "watchers", Message: "no watchers are configured"})
+	}
+
+	names := make([]string, 0, len(watchers))
+	for name := range watchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		watcher := watchers[name]
+		path := joinConfigPath("watchers", name)
+		if _, _, err := ConfigWatcher(name, watcher); err != nil {
+			problems = append(problems, ConfigProblem{Path: path, Message: err.Error()})
+		}
+		templates, _ := watcher.ObjectArray("templates")
+		for n, tpl := range templates {
+			tplPath := joinConfigPath(path, fmt.Sprintf("templates.%d.src", n))
+			src := tpl.StringDflt("src", "")
+			if src == "" {
+				continue
+			}
+			if _, err := template.New(filepath.Base(src)).Funcs(TemplateFuncs()).ParseFiles(src); err != nil {
+				problems = append(problems, ConfigProblem{Path: tplPath, Message: err.Error()})
+			}
+		}
+
+		cmdPath := joinConfigPath(path, "command")
+		switch watcher.StringDflt("type", WatcherTypeCommand) {
+		case WatcherTypeWebhook:
+			continue
+		case WatcherTypeKV:
+			if src := watcher.StringDflt("kv.src", ""); src != "" {
+				if _, err := template.New(filepath.Base(src)).Funcs(TemplateFuncs()).ParseFiles(src); err != nil {
+					problems = append(problems, ConfigProblem{Path: joinConfigPath(path, "kv.src"), Message: err.Error()})
+				}
+			}
+			continue
+		}
+		if dir := watcher.StringDflt("dir", ""); dir != "" {
+			if info, err := os.Stat(dir); err != nil {
+				problems = append(problems, ConfigProblem{Path: joinConfigPath(path, "dir"), Message: err.Error()})
+			} else if !info.IsDir() {
+				problems = append(problems, ConfigProblem{Path: joinConfigPath(path, "dir"), Message: dir + " is not a directory"})
+			}
+		}
+		commandTemplate := watcher.BoolDflt("command-template", false)
+		if cmdStr, err := watcher.String("command"); err == nil {
+			if _, err := parseCommandArg(cmdStr); commandTemplate && err != nil {
+				problems = append(problems, ConfigProblem{Path: cmdPath, Message: err.Error()})
+			}
+			if _, err := exec.LookPath("bash"); err != nil {
+				problems = append(problems, ConfigProblem{Path: cmdPath, Message: err.Error()})
+			}
+			if fields := strings.Fields(cmdStr); len(fields) > 0 {
+				if _, err := exec.LookPath(fields[0]); err != nil {
+					msg := fmt.Sprintf("%s is not on the PATH; it may be a shell builtin", fields[0])
+					problems = append(problems, ConfigProblem{Path: cmdPath, Message: msg, Warning: true})
+				}
+			}
+		} else if cmdArray, err := watcher.StringArray("command"); err == nil && len(cmdArray) > 0 {
+			for n, arg := range cmdArray {
+				if _, err := parseCommandArg(arg); commandTemplate && err != nil {
+					problems = append(problems, ConfigProblem{Path: joinConfigPath(cmdPath, fmt.Sprint(n)), Message: err.Error()})
+				}
+			}
+			if cmdArray[0] == "" {
+				problems = append(problems, ConfigProblem{Path: joinConfigPath(cmdPath, "0"), Message: "command is empty"})
+			} else if _, err := exec.LookPath(cmdArray[0]); err != nil {
+				problems = append(problems, ConfigProblem{Path: cmdPath, Message: err.Error()})
+			}
+		}
+	}
+	return problems
+}
+
+// Check the parts of the config outside of the watchers which are only
+// validated when Sentinel starts.
+func CheckSentinel(config *settings.Settings) []ConfigProblem {
+	problems := []ConfigProblem{}
+	client, err := NewEtcdClient(config.ObjectDflt("etcd", &settings.Settings{}))
+	if err != nil {
+		problems = append(problems, ConfigProblem{Path: "etcd", Message: err.Error()})
+	}
+	if _, err := ConfigElector(config, client); err != nil {
+		problems = append(problems, ConfigProblem{Path: "leader", Message: err.Error()})
+	}
+	switch format := config.StringDflt("logging.format", LogFormatText); format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		problems = append(problems, ConfigProblem{Path: "logging.format", Message: fmt.Sprintf("log format '%s' is invalid", format)})
+	}
+	if _, err := ConfigRedactor(config.ObjectDflt("logging.redact", &settings.Settings{})); err != nil {
+		problems = append(problems, ConfigProblem{Path: "logging.redact", Message: err.Error()})
+	}
+	return problems
+}
+
+// Check the config described by `options` and write any problems found to
+// `out`. The config is validated as it is when Sentinel starts. Return true if no errors were found.
+func CheckConfig(options *Options, out io.Writer) bool {
+	problems := CheckFile(options.Config, ConfigSchema, os.LookupEnv)
+	if config, err := configure(options); err != nil {
+		problems = append(problems, ConfigProblem{File: options.Config, Message: err.Error()})
+	} else {
+		if paths, err := ConfigIncludePaths(filepath.Dir(options.Config), includePatterns(config, options)); err == nil {
+			for _, path := range paths {
+				problems = append(problems, CheckFile(path, IncludeSchema, os.LookupEnv)...)
+			}
+		}
+		problems = append(problems, CheckSentinel(config)...)
+		problems = append(problems, CheckWatchers(config)...)
+	}
+
+	ok := true
+	for _, problem := range problems {
+		fmt.Fprintln(out, problem)
+		if !problem.Warning {
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Fprintf(out, "%s is valid\n", options.Config)
+	}
+	return ok
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml": `
+etcd:
+  uris: http://localhost:4001
+  prefix: [nope]
+watchers:
+  nginx:
+    watch: [nginx]
+    comand: echo hello
+    templates:
+    - src: nginx.tpl
+    - dest: nginx.conf
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	want := []ConfigProblem{
+		{File: file, Path: "etcd.prefix", Message: "must be a string"},
+		{File: file, Path: "watchers.nginx.comand", Message: "unknown key", Warning: true},
+		{File: file, Path: "watchers.nginx.templates.0.dest", Message: "is missing"},
+		{File: file, Path: "watchers.nginx.templates.1.src", Message: "is missing"},
+	}
+	have := CheckFile(file, ConfigSchema, mockEnv(map[string]string{}))
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
+
+func TestCheckFileEnv(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml": `
+on-start: "${ON_START}"
+audit:
+  max-diff: ${MAX_DIFF:-10}
+watchers:
+  nginx:
+    stdin: maybe
+    webhook:
+      url: http://localhost/
+      retries: ${RETRIES}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	lookup := mockEnv(map[string]string{
+		"ON_START":                      "false",
+		"RETRIES":                       "3",
+		"SENTINEL_WATCHERS_NGINX_STDIN": "true",
+	})
+	if have := CheckFile(file, ConfigSchema, lookup); len(have) != 0 {
+		t.Errorf("expanded config has problems: %v", have)
+	}
+
+	lookup = mockEnv(map[string]string{"RETRIES": "3", "SENTINEL_WATCHERS_NGINX_STDIN": "true"})
+	want := []ConfigProblem{
+		{File: file, Message: "config 'on-start' is invalid: variable ON_START is not set"},
+	}
+	if have := CheckFile(file, ConfigSchema, lookup); !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
+
+func TestCheckWatchers(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"good.tpl": "{{ .value }}",
+		"bad.tpl":  "{{ .value ",
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	content := strings.Replace(`
+watchers:
+  good:
+    templates:
+    - src: DIR/good.tpl
+      dest: DIR/good.out
+    command: [sh, -c, "true"]
+  bad:
+    templates:
+    - src: DIR/bad.tpl
+      dest: DIR/bad.out
+    - src: DIR/missing.tpl
+      dest: DIR/missing.out
+    command: [sirnotappearinginthisfilm]
+  empty:
+    watch: [empty]
+  type:
+    type: bogus
+    command: [sh, -c, "true"]
+  interval:
+    interval: 10ms
+    command: [sh, -c, "true"]
+  signal:
+    signal:
+      signal: SIGBOGUS
+      pidfile: /var/run/bogus.pid
+  user:
+    user: sirnotappearinginthisfilm
+    command: [sh, -c, "true"]
+`, "DIR", dir, -1)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := settings.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := map[string]bool{}
+	for _, problem := range CheckWatchers(config) {
+		t.Log(problem)
+		have[problem.Path] = problem.Warning
+	}
+	want := map[string]bool{
+		"watchers.bad.templates.0.src": false,
+		"watchers.bad.templates.1.src": false,
+		"watchers.bad.command":         false,
+		"watchers.empty":               false,
+		"watchers.type":                false,
+		"watchers.interval":            false,
+		"watchers.signal":              false,
+		"watchers.user":                false,
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
+
+func TestCheckSentinel(t *testing.T) {
+	config := &settings.Settings{}
+	config.Set("logging.format", "xml")
+	config.Set("leader.ttl", "10ms")
+
+	have := map[string]bool{}
+	for _, problem := range CheckSentinel(config) {
+		t.Log(problem)
+		have[problem.Path] = problem.Warning
+	}
+	want := map[string]bool{
+		"logging.format": false,
+		"leader":         false,
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
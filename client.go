@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/peterbourgon/mergemap"
-	"gopkg.in/BlueDragonX/go-log.v1"
 	"gopkg.in/BlueDragonX/go-settings.v1"
 	stdlog "log"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -19,6 +19,34 @@ const (
 
 var DefaultEtcdURIs []string = []string{"http://172.17.42.1:4001/"}
 
+// Event actions which are not caused by a change to a key.
+const (
+	// The watch on a prefix failed and is backing off.
+	ActionError = "error"
+
+	// The watch on a prefix recovered from a failure.
+	ActionReconnect = "reconnect"
+
+	// The watch on a prefix was reset because the server cleared the history
+	// of its index. Changes may have been missed.
+	ActionReset = "reset"
+)
+
+// Describes a change to a watched prefix.
+type Event struct {
+	// The watched prefix.
+	Prefix string `json:"prefix"`
+
+	// The key which changed. This is the prefix or a key under it.
+	Key string `json:"key"`
+
+	// The action which caused the change, e.g. set or delete.
+	Action string `json:"action"`
+
+	// The server index at which the change occurred.
+	Index uint64 `json:"index"`
+}
+
 // Configuration server client interface.
 type Client interface {
 	// Wait for the server to become available. The wait can be stopped by
@@ -30,12 +58,34 @@ type Client interface {
 	// merged into a map of values structured as a tree.
 	Get(keys []string) (map[string]interface{}, error)
 
-	// Recursively watch each prefix in `prefixes` for changes. Send the name
-	// of the changed prefix to the `changes` channel. Stop watching and exit
+	// Recursively watch each prefix in `prefixes` for changes. Send an event
+	// describing each change to the `changes` channel. Stop watching and exit
 	// when `stop` receives `true`. Wait for the server to become available if
 	// it isn't. Each failed watch attempt will be followed by an increasingly
-	// longer period of sleep.
-	Watch(prefixes []string, changes chan string, stop chan bool)
+	// longer period of sleep. An `ActionError` event is sent when a watch
+	// starts failing and an `ActionReconnect` event when it recovers. Each
+	// prefix in `indexes` resumes after the given index. An `ActionReset`
+	// event is sent if the server no longer has the history of that index.
+	Watch(prefixes []string, indexes map[string]uint64, changes chan *Event, stop chan bool)
+
+	// Set `key` to `value` if it does not exist. The key expires after `ttl`
+	// unless it is zero. Return false if the key exists.
+	Create(key, value string, ttl time.Duration) (bool, error)
+
+	// Set `key` to `value` if its current value is `prev`. The key expires
+	// after `ttl` unless it is zero. Return false if the value differs or the
+	// key does not exist.
+	CompareAndSwap(key, value, prev string, ttl time.Duration) (bool, error)
+
+	// Delete `key` if its current value is `prev`. Return false if the value
+	// differs or the key does not exist.
+	CompareAndDelete(key, prev string) (bool, error)
+
+	// Set `key` to `value`. The key expires after `ttl` unless it is zero.
+	Set(key, value string, ttl time.Duration) error
+
+	// Delete `key`. Deleting a key which does not exist is not an error.
+	Delete(key string) error
 }
 
 // Return the base key name for a key path.
@@ -80,6 +130,8 @@ func getNodeMap(node *etcd.Node) map[string]interface{} {
 // An etcd client implementation.
 type EtcdClient struct {
 	client *etcd.Client
+	uris   []string
+	tls    []string
 }
 
 // Create a new etcd client.
@@ -108,9 +160,19 @@ func NewEtcdClient(config *settings.Settings) (*EtcdClient, error) {
 
 	return &EtcdClient{
 		client: etcdClient,
+		uris:   uris,
+		tls:    []string{tlsKey, tlsCert, tlsCaCert},
 	}, nil
 }
 
+// Return true if `other` is an etcd client configured the same way.
+func (c *EtcdClient) Equal(other Client) bool {
+	if otherEtcd, ok := other.(*EtcdClient); ok {
+		return reflect.DeepEqual(c.uris, otherEtcd.uris) && reflect.DeepEqual(c.tls, otherEtcd.tls)
+	}
+	return false
+}
+
 // Wait for the server to become available. The wait can be stopped by sending
 // a value to `stop` or closing it. Return true if the server came online or
 // false if the wait was canceled.
@@ -168,22 +230,144 @@ func (c *EtcdClient) Get(keys []string) (map[string]interface{}, error) {
 	return mapping, err
 }
 
+// Convert a time to live to whole seconds. Partial seconds are rounded up.
+func ttlSeconds(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64((ttl + time.Second - 1) / time.Second)
+}
+
+// Return true if `err` is an etcd error with one of the given `codes`.
+func isEtcdError(err error, codes ...int) bool {
+	if etcdErr, ok := err.(*etcd.EtcdError); ok {
+		for _, code := range codes {
+			if etcdErr.ErrorCode == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Set `key` to `value` if it does not exist. The key expires after `ttl`
+// unless it is zero. Return false if the key exists.
+func (c *EtcdClient) Create(key, value string, ttl time.Duration) (bool, error) {
+	_, err := c.client.Create(key, value, ttlSeconds(ttl))
+	if isEtcdError(err, 105) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Set `key` to `value` if its current value is `prev`. The key expires after
+// `ttl` unless it is zero. Return false if the value differs or the key does
+// not exist.
+func (c *EtcdClient) CompareAndSwap(key, value, prev string, ttl time.Duration) (bool, error) {
+	_, err := c.client.CompareAndSwap(key, value, ttlSeconds(ttl), prev, 0)
+	if isEtcdError(err, 100, 101) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete `key` if its current value is `prev`. Return false if the value
+// differs or the key does not exist.
+func (c *EtcdClient) CompareAndDelete(key, prev string) (bool, error) {
+	_, err := c.client.CompareAndDelete(key, prev, 0)
+	if isEtcdError(err, 100, 101) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Set `key` to `value`. The key expires after `ttl` unless it is zero.
+func (c *EtcdClient) Set(key, value string, ttl time.Duration) error {
+	_, err := c.client.Set(key, value, ttlSeconds(ttl))
+	return err
+}
+
+// Delete `key`. Deleting a key which does not exist is not an error.
+func (c *EtcdClient) Delete(key string) error {
+	_, err := c.client.Delete(key, false)
+	if isEtcdError(err, 100) {
+		return nil
+	}
+	return err
+}
+
+// Append the keys of the leaf nodes under `node` to `keys`.
+func getNodeKeys(node *etcd.Node, keys []string) []string {
+	if !node.Dir {
+		return append(keys, node.Key)
+	}
+	for _, child := range node.Nodes {
+		keys = getNodeKeys(child, keys)
+	}
+	return keys
+}
+
+// Recursively list the keys of the values under a group of `keys`. The keys
+// are returned as stored on the server.
+func (c *EtcdClient) Keys(keys []string) ([]string, error) {
+	found := []string{}
+	for _, key := range keys {
+		response, err := c.client.Get(key, true, true)
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == 100 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = getNodeKeys(response.Node, found)
+	}
+	return found, nil
+}
+
 // Watch a single prefix for changes.
-func (c *EtcdClient) watchOne(prefix string, changes chan string, stop chan bool) {
+func (c *EtcdClient) watchOne(prefix string, index uint64, changes chan *Event, stop chan bool) {
 	prefix = strings.Trim(prefix, "/")
 	var waitIndex uint64 = 0
+	if index > 0 {
+		waitIndex = index + 1
+	}
 	var retryTime int64 = retrySeed
+	failed := false
 	logger.Debugf("watching %s for changes", prefix)
 
 Loop:
 	for {
 		var err error
 		var response *etcd.Response
-		if response, err = c.client.Watch(prefix, waitIndex, true, nil, stop); err == nil {
+		if failed {
+			// Probe the server before resuming the watch. Otherwise recovery
+			// would go unnoticed until the prefix changes.
+			if _, err = c.client.Get("/", false, false); err == nil {
+				failed = false
+				retryTime = retrySeed
+				logger.Infof("watch on %s reconnected", prefix)
+				changes <- &Event{Prefix: prefix, Key: prefix, Action: ActionReconnect, Index: waitIndex}
+				continue
+			}
+		} else {
+			response, err = c.client.Watch(prefix, waitIndex, true, nil, stop)
+		}
+
+		if err == nil {
 			waitIndex = response.EtcdIndex + 1
 			retryTime = retrySeed
 			logger.Debugf("prefix %s changed, index was %d, action was %s", prefix, response.EtcdIndex, response.Action)
-			changes <- prefix
+			event := &Event{
+				Prefix: prefix,
+				Key:    prefix,
+				Action: response.Action,
+				Index:  response.EtcdIndex,
+			}
+			if response.Node != nil {
+				event.Key = strings.Trim(response.Node.Key, "/")
+				event.Index = response.Node.ModifiedIndex
+			}
+			watchEvents.Inc(prefix)
+			changes <- event
 		} else if _, ok := err.(*json.SyntaxError); ok {
 			// This is caused by the connection timing out thus cutting the
 			// stream the JSON encoder is reading from. I would expect this to
@@ -193,6 +377,7 @@ Loop:
 			// issue when directly connected. Either way we will retry the
 			// watch using the same index so as not to miss any changes.
 			retryTime = retrySeed
+			watchErrors.Inc(prefix, "timeout")
 			logger.Debugf("watch on %s timed out, retrying immediately", prefix)
 		} else if err == etcd.ErrWatchStoppedByUser {
 			err = nil
@@ -202,11 +387,18 @@ Loop:
 			// etcd starts clearing the history. This should happen if we miss
 			// 1000 events.
 			retryTime = retrySeed
+			watchErrors.Inc(prefix, "index_cleared")
 			logger.Errorf("watch on %s index %d cleared, reset to 0", prefix, waitIndex)
+			changes <- &Event{Prefix: prefix, Key: prefix, Action: ActionReset, Index: etcdErr.Index}
 			waitIndex = 0
 		} else {
+			watchErrors.Inc(prefix, "connection")
 			logger.Errorf("watch on %s failed, retrying in %.1f seconds", prefix, float64(retryTime)/1000)
 			logger.Debugf("error was: %s", err)
+			if !failed {
+				failed = true
+				changes <- &Event{Prefix: prefix, Key: prefix, Action: ActionError, Index: waitIndex}
+			}
 
 			select {
 			case <-time.After(time.Duration(retryTime) * time.Millisecond):
@@ -223,11 +415,12 @@ Loop:
 	}
 }
 
-// Recursively watch each prefix in `prefixes` for changes. Send the name of
-// changed prefix to the `changes` channel. Stop watching and exit when `stop`
-// receives `true`. Wait for the server to become available if it isn't. Each
-// failed attempt will be followed by an increasingly longer period of sleep.
-func (c *EtcdClient) Watch(prefixes []string, changes chan string, stop chan bool) {
+// Recursively watch each prefix in `prefixes` for changes. Send an event
+// describing each change to the `changes` channel. Stop watching and exit when
+// `stop` receives `true`. Wait for the server to become available if it isn't.
+// Each failed attempt will be followed by an increasingly longer period of
+// sleep. Each prefix in `indexes` resumes after the given index.
+func (c *EtcdClient) Watch(prefixes []string, indexes map[string]uint64, changes chan *Event, stop chan bool) {
 	defer close(changes)
 	type syncStore struct {
 		stop chan bool
@@ -241,7 +434,7 @@ func (c *EtcdClient) Watch(prefixes []string, changes chan string, stop chan boo
 			make(chan bool),
 		}
 		go func(prefix string, sync syncStore) {
-			c.watchOne(prefix, changes, sync.stop)
+			c.watchOne(prefix, indexes[prefix], changes, sync.stop)
 			close(sync.join)
 		}(prefix, syncs[n])
 	}
@@ -259,7 +452,6 @@ func (c *EtcdClient) Watch(prefixes []string, changes chan string, stop chan boo
 }
 
 func init() {
-	logWriter := log.NewWriter(log.LevelDebug, logger)
-	etcdLogger := stdlog.New(logWriter, "go-etcd: ", 0)
+	etcdLogger := stdlog.New(logger.Writer("debug"), "go-etcd: ", 0)
 	etcd.SetLogger(etcdLogger)
 }
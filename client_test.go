@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,7 +21,17 @@ type MockClient struct {
 	WaitFor  time.Duration
 	GetValue map[string]interface{}
 	GetError error
-	Changes  chan string
+	KeyValue []string
+	Changes  chan *Event
+	Watching map[string]int
+	Indexes  map[string]uint64
+	Values   map[string]*MockValue
+	lock     sync.Mutex
+}
+
+type MockValue struct {
+	Value   string
+	Expires time.Time
 }
 
 func (mc *MockClient) Wait(stop chan bool) bool {
@@ -32,14 +43,154 @@ func (mc *MockClient) Wait(stop chan bool) bool {
 	return true
 }
 
+// Return GetValue if set. Otherwise return the unexpired Values.
 func (mc *MockClient) Get(keys []string) (map[string]interface{}, error) {
-	return mc.GetValue, mc.GetError
+	if mc.GetValue != nil || mc.GetError != nil {
+		return mc.GetValue, mc.GetError
+	}
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if len(mc.Values) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]interface{})
+	for key := range mc.Values {
+		value, ok := mc.value(key)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(CleanPath(key), "/")
+		dir := values
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := dir[getKeyName(part)].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				dir[getKeyName(part)] = child
+			}
+			dir = child
+		}
+		dir[getKeyName(parts[len(parts)-1])] = value.Value
+	}
+	return values, nil
+}
+
+func (mc *MockClient) Keys(keys []string) ([]string, error) {
+	return mc.KeyValue, mc.GetError
+}
+
+// Return the unexpired value of `key`. The lock must be held.
+func (mc *MockClient) value(key string) (*MockValue, bool) {
+	if mc.Values == nil {
+		mc.Values = make(map[string]*MockValue)
+	}
+	value, ok := mc.Values[key]
+	if ok && !value.Expires.IsZero() && time.Now().After(value.Expires) {
+		delete(mc.Values, key)
+		return nil, false
+	}
+	return value, ok
+}
+
+// Set `key` to `value`. The lock must be held.
+func (mc *MockClient) set(key, value string, ttl time.Duration) {
+	mockValue := &MockValue{Value: value}
+	if ttl > 0 {
+		mockValue.Expires = time.Now().Add(ttl)
+	}
+	mc.Values[key] = mockValue
+}
+
+func (mc *MockClient) Create(key, value string, ttl time.Duration) (bool, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if _, ok := mc.value(key); ok {
+		return false, nil
+	}
+	mc.set(key, value, ttl)
+	return true, nil
+}
+
+func (mc *MockClient) CompareAndSwap(key, value, prev string, ttl time.Duration) (bool, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if current, ok := mc.value(key); !ok || current.Value != prev {
+		return false, nil
+	}
+	mc.set(key, value, ttl)
+	return true, nil
+}
+
+func (mc *MockClient) CompareAndDelete(key, prev string) (bool, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if current, ok := mc.value(key); !ok || current.Value != prev {
+		return false, nil
+	}
+	delete(mc.Values, key)
+	return true, nil
+}
+
+func (mc *MockClient) Set(key, value string, ttl time.Duration) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.value(key)
+	mc.set(key, value, ttl)
+	return nil
 }
 
-func (mc *MockClient) Watch(prefixes []string, changes chan string, stop chan bool) {
+func (mc *MockClient) Delete(key string) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.value(key)
+	delete(mc.Values, key)
+	return nil
+}
+
+// Send `event` to the changes channel of the running watch.
+func (mc *MockClient) Send(event *Event) {
+	mc.lock.Lock()
+	changes := mc.Changes
+	mc.lock.Unlock()
+	changes <- event
+}
+
+func (mc *MockClient) Watch(prefixes []string, indexes map[string]uint64, changes chan *Event, stop chan bool) {
+	mc.lock.Lock()
 	mc.Changes = changes
+	if mc.Watching == nil {
+		mc.Watching = make(map[string]int)
+	}
+	if mc.Indexes == nil {
+		mc.Indexes = make(map[string]uint64)
+	}
+	for _, prefix := range prefixes {
+		mc.Watching[prefix]++
+		mc.Indexes[prefix] = indexes[prefix]
+	}
+	mc.lock.Unlock()
+
 	<-stop
+
+	mc.lock.Lock()
+	for _, prefix := range prefixes {
+		mc.Watching[prefix]--
+	}
+	mc.lock.Unlock()
 }
+
+// Return the number of watches running on `prefix`.
+func (mc *MockClient) Watches(prefix string) int {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.Watching[prefix]
+}
+
+func (mc *MockClient) WatchIndex(prefix string) uint64 {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.Indexes[prefix]
+}
+
 func getEtcdClient(t *testing.T, uri string) *EtcdClient {
 	config := settings.Settings{}
 	if uri != "" {
@@ -196,10 +347,10 @@ func TestEtcdClientWatch(t *testing.T) {
 
 	// server down should return an error
 	join := make(chan bool)
-	changes := make(chan string)
+	changes := make(chan *Event)
 	stop := make(chan bool)
 	go func() {
-		client.Watch([]string{"test/index"}, changes, stop)
+		client.Watch([]string{"test/index"}, nil, changes, stop)
 		close(join)
 	}()
 
@@ -208,15 +359,114 @@ func TestEtcdClientWatch(t *testing.T) {
 		rawClient.Set("/test/index", "2", 0)
 	}()
 
-	if key := <-changes; key != "test/index" {
-		t.Errorf("changed key is '%s' not 'test/index'", key)
+	if event := <-changes; event.Prefix != "test/index" {
+		t.Errorf("changed prefix is '%s' not 'test/index'", event.Prefix)
+	} else if event.Key != "test/index" {
+		t.Errorf("changed key is '%s' not 'test/index'", event.Key)
 	} else {
-		t.Logf("changed key is '%s'\n", key)
+		t.Logf("changed key is '%s' at index %d\n", event.Key, event.Index)
 	}
 	stop <- true
 	<-join
 }
 
+// Ensure Create, CompareAndSwap, and CompareAndDelete only write when their
+// condition holds.
+func TestEtcdClientCompare(t *testing.T) {
+	client := getEtcdClient(t, validURI)
+	rawClient := client.client
+	etcdClientSetUp(t, rawClient)
+	defer etcdClientTearDown(t, rawClient)
+
+	// create when the key exists
+	if ok, err := client.Create("test/index", "2", 0); err != nil || ok {
+		t.Errorf("create of existing key returned %t: %v", ok, err)
+	}
+	if ok, err := client.Create("test/created", "1", 0); err != nil || !ok {
+		t.Errorf("create of missing key returned %t: %v", ok, err)
+	}
+
+	// swap with a stale value
+	if ok, err := client.CompareAndSwap("test/index", "3", "stale", 0); err != nil || ok {
+		t.Errorf("swap with stale value returned %t: %v", ok, err)
+	}
+	if ok, err := client.CompareAndSwap("test/missing", "3", "1", 0); err != nil || ok {
+		t.Errorf("swap of missing key returned %t: %v", ok, err)
+	}
+	if ok, err := client.CompareAndSwap("test/index", "3", "1", 0); err != nil || !ok {
+		t.Errorf("swap with current value returned %t: %v", ok, err)
+	}
+
+	// delete a missing key or with a stale value
+	if ok, err := client.CompareAndDelete("test/missing", "1"); err != nil || ok {
+		t.Errorf("delete of missing key returned %t: %v", ok, err)
+	}
+	if ok, err := client.CompareAndDelete("test/index", "1"); err != nil || ok {
+		t.Errorf("delete with stale value returned %t: %v", ok, err)
+	}
+	if ok, err := client.CompareAndDelete("test/index", "3"); err != nil || !ok {
+		t.Errorf("delete with current value returned %t: %v", ok, err)
+	}
+
+	want := map[string]interface{}{"test": map[string]interface{}{"created": "1"}}
+	if have, err := client.Get([]string{"test/index", "test/created"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
+
+// Ensure Set and Delete write keys and that keys set with a TTL expire.
+func TestEtcdClientSetDelete(t *testing.T) {
+	client := getEtcdClient(t, validURI)
+	rawClient := client.client
+	etcdClientSetUp(t, rawClient)
+	defer etcdClientTearDown(t, rawClient)
+
+	if err := client.Set("test/index", "2", 0); err != nil {
+		t.Error(err)
+	}
+	if err := client.Set("test/expires", "1", time.Second); err != nil {
+		t.Error(err)
+	}
+	want := map[string]interface{}{"test": map[string]interface{}{"index": "2", "expires": "1"}}
+	if have, err := client.Get([]string{"test/index", "test/expires"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+
+	if err := client.Delete("test/index"); err != nil {
+		t.Error(err)
+	}
+	if err := client.Delete("test/missing"); err != nil {
+		t.Errorf("delete of missing key failed: %s", err)
+	}
+
+	// wait for the key with a TTL to expire
+	time.Sleep(2500 * time.Millisecond)
+	want = map[string]interface{}{}
+	if have, err := client.Get([]string{"test/index", "test/expires"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+}
+
+// Ensure clients are equal only when configured the same.
+func TestEtcdClientEqual(t *testing.T) {
+	a := getEtcdClient(t, validURI)
+	if b := getEtcdClient(t, validURI+"/"); !a.Equal(b) {
+		t.Error("clients with the same uri are not equal")
+	}
+	if b := getEtcdClient(t, invalidURI); a.Equal(b) {
+		t.Error("clients with different uris are equal")
+	}
+	if a.Equal(&MockClient{}) {
+		t.Error("etcd client is equal to a mock client")
+	}
+}
+
 // Ensure URIs with and without trailing slashes work.
 func TestEtcdClientTrailingSlash(t *testing.T) {
 	client := getEtcdClient(t, validURI)
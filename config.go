@@ -1,76 +1,458 @@
 package main
 
 import (
+	"fmt"
 	"gopkg.in/BlueDragonX/go-settings.v1"
+	"gopkg.in/yaml.v2"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func ConfigTemplates(configs []*settings.Settings) []Template {
+// Set a config value from a `key=value` string. The key is a dotted path into
+// the config. The value is converted to the type the schema expects at that
+// path. Lists are given in YAML flow style, e.g. `[a, b]`. Values at unknown
+// paths are parsed as YAML.
+func ConfigOverride(config *settings.Settings, override string) error {
+	parts := strings.SplitN(override, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("override '%s' is not of the form key=value", override)
+	}
+	key, raw := parts[0], parts[1]
+
+	var err error
+	var value interface{} = raw
+	schema := lookupSchema(key)
+	switch {
+	case schema == nil || (schema.Type == typeStrings && strings.HasPrefix(raw, "[")):
+		var parsed interface{}
+		if err = yaml.Unmarshal([]byte(raw), &parsed); err == nil && parsed != nil {
+			value = parsed
+		}
+		err = nil
+	case schema.Type == typeBool:
+		value, err = strconv.ParseBool(raw)
+	case schema.Type == typeInt:
+		value, err = strconv.Atoi(raw)
+	case schema.Type == typeObject || schema.Type == typeMap || schema.Type == typeList:
+		err = fmt.Errorf("not a single value")
+	}
+	if err != nil {
+		return fmt.Errorf("override '%s' is invalid: %s", override, err)
+	}
+
+	config.Set(key, value)
+	return nil
+}
+
+// Return the paths to the files matching the glob `patterns`. Relative
+// patterns are resolved against `dir`.
+func ConfigIncludePaths(dir string, patterns []string) ([]string, error) {
+	paths := []string{}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("config include '%s' is invalid: %s", pattern, err)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Load the drop-in files matching the glob `patterns` and merge their watchers
+// into `config`. Relative patterns are resolved against `dir`. A watcher name
+// may only be defined once across the config and all of its drop-ins.
+// Environment variable references in the drop-ins are expanded with `lookup`
+// unless it is nil.
+func ConfigIncludes(config *settings.Settings, file, dir string, patterns []string, lookup EnvLookup) error {
+	definedIn := make(map[string]string)
+	for name := range config.ObjectMapDflt("watchers", map[string]*settings.Settings{}) {
+		definedIn[name] = file
+	}
+
+	paths, err := ConfigIncludePaths(dir, patterns)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		include, err := settings.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %s", path, err)
+		}
+		if lookup != nil {
+			if err := configExpandEnv(include, IncludeSchema, lookup); err != nil {
+				return fmt.Errorf("%s in %s", err, path)
+			}
+		}
+		watchers, err := include.ObjectMap("watchers")
+		if err == settings.KeyError {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("config 'watchers' in %s is invalid", path)
+		}
+
+		names := make([]string, 0, len(watchers))
+		for name := range watchers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if other, ok := definedIn[name]; ok {
+				return fmt.Errorf("watcher %s in %s is already defined in %s", name, path, other)
+			}
+			value, err := include.Raw("watchers." + name)
+			if err != nil {
+				return fmt.Errorf("config 'watchers.%s' in %s is invalid", name, path)
+			}
+			config.Set("watchers."+name, value)
+			definedIn[name] = path
+		}
+	}
+	return nil
+}
+
+func ConfigTemplates(configs []*settings.Settings) ([]Template, error) {
 	templates := make([]Template, len(configs))
 	for n, config := range configs {
 		src := config.StringDflt("src", "")
 		dest := config.StringDflt("dest", "")
 		if src == "" {
-			logger.Fatalf("config '%s.src' is missing", config.Key)
+			return nil, fmt.Errorf("config '%s.src' is missing", config.Key)
 		}
 		if dest == "" {
-			logger.Fatalf("config '%s.dest' is missing", config.Key)
+			return nil, fmt.Errorf("config '%s.dest' is missing", config.Key)
 		}
 		templates[n] = Template{Src: src, Dest: dest}
 	}
-	return templates
+	return templates, nil
+}
+
+// Parse the lock of the watcher `name`. The lock key is resolved against
+// `prefix` and defaults to `locks/<name>`.
+func ConfigLock(config *settings.Settings, prefix, name string) (*LockConfig, error) {
+	key := config.StringDflt("key", JoinPath("locks", name))
+	ttl, err := time.ParseDuration(config.StringDflt("ttl", DefaultLockTTL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("config '%s.ttl' is invalid: %s", config.Key, err)
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("config '%s.ttl' must be at least one second", config.Key)
+	}
+	return &LockConfig{
+		Key:  JoinPath(prefix, key),
+		TTL:  ttl,
+		Wait: config.BoolDflt("wait", false),
+	}, nil
+}
+
+// Look up the credential to run commands as. Each of `userName` and
+// `groupName` is a name or numeric id. The group defaults to the user's primary
+// group. The supplementary groups are the user's or Sentinel's own if no user
+// is set. Return nil if neither is set.
+func ConfigCredential(userName, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return nil, fmt.Errorf("user %s not found", userName)
+			}
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+		groupIds, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("groups of user %s not found: %s", userName, err)
+		}
+		cred.Groups = make([]uint32, 0, len(groupIds))
+		for _, groupId := range groupIds {
+			if gid, err := strconv.ParseUint(groupId, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(gid))
+			}
+		}
+	} else {
+		cred.NoSetGroups = true
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("group %s not found", groupName)
+			}
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		cred.Gid = uint32(gid)
+	}
+	return cred, nil
+}
+
+// Return the string map at `key` in `config`.
+func configStringMap(config *settings.Settings, key string) (map[string]string, error) {
+	values := map[string]string{}
+	value, err := config.Raw(key)
+	if err != nil {
+		return values, nil
+	}
+	valueMap, ok := normalizeYAML(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config '%s.%s' is invalid", config.Key, key)
+	}
+	for name, item := range valueMap {
+		values[name] = fmt.Sprint(item)
+	}
+	return values, nil
+}
+
+// Create the signal configured in `config`. Exactly one target is required.
+func ConfigSignal(config *settings.Settings) (*SignalConfig, error) {
+	name, signal, err := ParseSignal(config.StringDflt("signal", DefaultSignal))
+	if err != nil {
+		return nil, fmt.Errorf("config '%s.signal' is invalid: %s", config.Key, err)
+	}
+	sig := &SignalConfig{
+		Name:    name,
+		Signal:  signal,
+		Pidfile: config.StringDflt("pidfile", ""),
+		Process: config.StringDflt("process-name", ""),
+		Cgroup:  config.StringDflt("cgroup", ""),
+	}
+	targets := 0
+	for _, target := range []string{sig.Pidfile, sig.Process, sig.Cgroup} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("config '%s' requires one of pidfile, process-name, or cgroup", config.Key)
+	}
+	return sig, nil
 }
 
-func ConfigSentinel(config *settings.Settings) *Sentinel {
+// Create the kv write configured in `config`. The key is resolved against
+// `prefix`.
+func ConfigKV(config *settings.Settings, prefix string) (*KVConfig, error) {
+	key := config.StringDflt("key", "")
+	if key == "" {
+		return nil, fmt.Errorf("config '%s.key' is missing", config.Key)
+	}
+	src := config.StringDflt("src", "")
+	if src == "" {
+		return nil, fmt.Errorf("config '%s.src' is missing", config.Key)
+	}
+	ttl, err := time.ParseDuration(config.StringDflt("ttl", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("config '%s.ttl' is invalid: %s", config.Key, err)
+	}
+	if ttl != 0 && ttl < time.Second {
+		return nil, fmt.Errorf("config '%s.ttl' must be at least one second", config.Key)
+	}
+	return &KVConfig{
+		Key:      JoinPath(prefix, key),
+		Template: Template{Src: src},
+		TTL:      ttl,
+	}, nil
+}
+
+// Create the webhook configured in `config`.
+func ConfigWebhook(config *settings.Settings) (*Webhook, error) {
+	headers, err := configStringMap(config, "headers")
+	if err != nil {
+		return nil, err
+	}
+	hook := &Webhook{
+		URL:     config.StringDflt("url", ""),
+		Headers: headers,
+		Secret:  config.StringDflt("secret", ""),
+		Retries: config.IntDflt("retries", 0),
+		Context: config.BoolDflt("context", false),
+	}
+	if hook.URL == "" {
+		return nil, fmt.Errorf("config '%s.url' is missing", config.Key)
+	}
+	if hook.Retries < 0 {
+		return nil, fmt.Errorf("config '%s.retries' must not be negative", config.Key)
+	}
+	if hook.Timeout, err = time.ParseDuration(config.StringDflt("timeout", DefaultWebhookTimeout.String())); err != nil {
+		return nil, fmt.Errorf("config '%s.timeout' is invalid: %s", config.Key, err)
+	}
+	if hook.RetryDelay, err = time.ParseDuration(config.StringDflt("retry-delay", DefaultWebhookRetryDelay.String())); err != nil {
+		return nil, fmt.Errorf("config '%s.retry-delay' is invalid: %s", config.Key, err)
+	}
+	return hook, nil
+}
+
+// Create the leader elector configured in the `leader` section. The key is
+// resolved against the etcd prefix.
+func ConfigElector(config *settings.Settings, client Client) (*Elector, error) {
+	leader := config.ObjectDflt("leader", &settings.Settings{Key: "leader"})
+	key := JoinPath(config.StringDflt("etcd.prefix", ""), leader.StringDflt("key", DefaultLeaderKey))
+	ttl, err := time.ParseDuration(leader.StringDflt("ttl", DefaultLockTTL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("config 'leader.ttl' is invalid: %s", err)
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("config 'leader.ttl' must be at least one second")
+	}
+	return NewElector(client, key, ttl), nil
+}
+
+func ConfigSentinel(config *settings.Settings) (*Sentinel, error) {
 	client, err := NewEtcdClient(config.ObjectDflt("etcd", &settings.Settings{}))
 	if err != nil {
-		logger.Fatalf("failed to create client: %s", err)
+		return nil, fmt.Errorf("failed to create client: %s", err)
 	}
 
-	sentinel := Sentinel{Client: client}
+	sentinel := Sentinel{
+		Client:    client,
+		OnStart:   config.BoolDflt("on-start", true),
+		StatePath: config.StringDflt("state.path", ""),
+	}
+	if sentinel.Elector, err = ConfigElector(config, client); err != nil {
+		return nil, err
+	}
 
 	watchers, err := config.ObjectMap("watchers")
 	if err != nil {
-		logger.Fatal("config 'watchers' is invalid")
+		return nil, fmt.Errorf("config 'watchers' is invalid")
 	}
 	if len(watchers) == 0 {
-		logger.Fatal("config 'watchers' is missing")
+		return nil, fmt.Errorf("config 'watchers' is missing")
 	}
 
 	for name, watcher := range watchers {
-		prefix := CleanPath(watcher.StringDflt("prefix", ""))
-		watch := ResolvePaths(prefix, watcher.StringArrayDflt("watch", []string{}))
-		context := ResolvePaths(prefix, watcher.StringArrayDflt("context", []string{}))
+		watch, executor, err := ConfigWatcher(name, watcher)
+		if err != nil {
+			return nil, err
+		}
+		sentinel.Add(watch, executor)
+	}
 
-		var templates []Template
-		templatesConfig, err := watcher.ObjectArray("templates")
-		if err != settings.KeyError {
-			if err != nil {
-				logger.Fatalf("config '%s.templates' is invalid", watcher.Key)
-			}
-			templates = ConfigTemplates(templatesConfig)
+	return &sentinel, nil
+}
+
+// Parse the watcher `name`. Return the keys it watches and its executor.
+func ConfigWatcher(name string, watcher *settings.Settings) ([]string, Executor, error) {
+	prefix := CleanPath(watcher.StringDflt("prefix", ""))
+	watch := ResolvePaths(prefix, watcher.StringArrayDflt("watch", []string{}))
+	context := ResolvePaths(prefix, watcher.StringArrayDflt("context", []string{}))
+
+	var templates []Template
+	templatesConfig, err := watcher.ObjectArray("templates")
+	if err != settings.KeyError {
+		if err != nil {
+			return nil, nil, fmt.Errorf("config '%s.templates' is invalid", watcher.Key)
+		}
+		if templates, err = ConfigTemplates(templatesConfig); err != nil {
+			return nil, nil, err
 		}
+	}
+
+	var command []string
+	if cmdStr, err := watcher.String("command"); err == nil {
+		command = []string{"bash", "-c", cmdStr}
+	} else if cmdArray, err := watcher.StringArray("command"); err == nil {
+		command = cmdArray
+	}
 
-		var command []string
-		if cmdStr, err := watcher.String("command"); err == nil {
-			command = []string{"bash", "-c", cmdStr}
-		} else if cmdArray, err := watcher.StringArray("command"); err == nil {
-			command = cmdArray
+	env, err := configStringMap(watcher, "env")
+	if err != nil {
+		return nil, nil, err
+	}
+	cred, err := ConfigCredential(watcher.StringDflt("user", ""), watcher.StringDflt("group", ""))
+	if err != nil {
+		return nil, nil, fmt.Errorf("watcher %s %s", name, err)
+	}
+
+	var signal *SignalConfig
+	if signalConfig, err := watcher.Object("signal"); err == nil {
+		if signal, err = ConfigSignal(signalConfig); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		if len(templates) == 0 && len(command) == 0 {
-			logger.Fatalf("watcher %s templates and command both missing", name)
+	var lock *LockConfig
+	if lockConfig, err := watcher.Object("lock"); err == nil {
+		if lock, err = ConfigLock(lockConfig, prefix, name); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		executor := &TemplateExecutor{
-			name:      name,
-			prefix:    prefix,
-			context:   context,
-			Templates: templates,
-			Command:   command,
+	var interval time.Duration
+	if intervalStr, err := watcher.String("interval"); err == nil {
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			return nil, nil, fmt.Errorf("config '%s.interval' is invalid: %s", watcher.Key, err)
+		}
+		if interval < time.Second {
+			return nil, nil, fmt.Errorf("config '%s.interval' must be at least one second", watcher.Key)
 		}
+	}
 
-		sentinel.Add(watch, executor)
+	ex := &TemplateExecutor{
+		name:            name,
+		prefix:          prefix,
+		context:         context,
+		Templates:       templates,
+		Command:         command,
+		CommandTemplate: watcher.BoolDflt("command-template", false),
+		Env:             env,
+		Dir:             watcher.StringDflt("dir", ""),
+		Stdin:           watcher.BoolDflt("stdin", false),
+		Cred:            cred,
+		Signal:          signal,
+		Lock:            lock,
+		Leader:          watcher.BoolDflt("leader-only", false),
+		Resync:          interval,
+		AuditDiff:       watcher.BoolDflt("audit-diff", true),
 	}
 
-	return &sentinel
+	var executor Executor = ex
+	switch watcherType := watcher.StringDflt("type", WatcherTypeCommand); watcherType {
+	case WatcherTypeCommand:
+		if len(templates) == 0 && len(command) == 0 && signal == nil {
+			return nil, nil, fmt.Errorf("watcher %s templates, signal, and command all missing", name)
+		}
+	case WatcherTypeWebhook:
+		if len(command) > 0 || signal != nil {
+			return nil, nil, fmt.Errorf("watcher %s command and signal are not supported by webhooks", name)
+		}
+		hookConfig, err := watcher.Object("webhook")
+		if err != nil {
+			return nil, nil, fmt.Errorf("watcher %s webhook is missing", name)
+		}
+		hook, err := ConfigWebhook(hookConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		executor = &WebhookExecutor{TemplateExecutor: ex, Webhook: hook}
+	case WatcherTypeKV:
+		if len(command) > 0 || signal != nil {
+			return nil, nil, fmt.Errorf("watcher %s command and signal are not supported by kv watchers", name)
+		}
+		kvConfig, err := watcher.Object("kv")
+		if err != nil {
+			return nil, nil, fmt.Errorf("watcher %s kv is missing", name)
+		}
+		kv, err := ConfigKV(kvConfig, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		executor = &KVExecutor{TemplateExecutor: ex, KV: kv}
+	default:
+		return nil, nil, fmt.Errorf("watcher %s type '%s' is invalid", name, watcherType)
+	}
+	return watch, executor, nil
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Write a set of config `files` to a temp directory and return its path.
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "sentinel_test_")
+	if err != nil {
+		t.Fatal("failed to create tempdir")
+	}
+	for name, content := range files {
+		file := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml":     "watchers:\n  main:\n    command: echo main\n",
+		"sentinel.d/a.yml": "watchers:\n  a:\n    command: echo a\n",
+		"sentinel.d/b.yml": "watchers:\n  b:\n    prefix: ${B_PREFIX}\n    command: echo b\n",
+		"sentinel.d/c.txt": "watchers:\n  c:\n    command: echo c\n",
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	config, err := settings.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := mockEnv(map[string]string{"B_PREFIX": "bee"})
+	if err := ConfigIncludes(config, file, dir, []string{"sentinel.d/*.yml"}, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	watchers := config.ObjectMapDflt("watchers", map[string]*settings.Settings{})
+	have := []string{}
+	for name := range watchers {
+		have = append(have, name)
+	}
+	sort.Strings(have)
+	want := []string{"a", "b", "main"}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+	if cmd := watchers["b"].StringDflt("command", ""); cmd != "echo b" {
+		t.Errorf("watcher b has command '%s'", cmd)
+	}
+	if prefix := watchers["b"].StringDflt("prefix", ""); prefix != "bee" {
+		t.Errorf("watcher b has prefix '%s'", prefix)
+	}
+}
+
+func TestConfigIncludesDuplicate(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml":        "watchers:\n  main:\n    command: echo main\n",
+		"sentinel.d/a.yml":    "watchers:\n  a:\n    command: echo a\n",
+		"sentinel.d/b.yml":    "watchers:\n  a:\n    command: echo b\n",
+		"sentinel.d/main.yml": "watchers:\n  main:\n    command: echo b\n",
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	patterns := [][]string{
+		{"sentinel.d/a.yml", "sentinel.d/b.yml"},
+		{"sentinel.d/main.yml"},
+	}
+	for _, pattern := range patterns {
+		config, err := settings.Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ConfigIncludes(config, file, dir, pattern, nil); err == nil {
+			t.Errorf("duplicate watcher in %v not detected", pattern)
+		} else if !strings.Contains(err.Error(), "already defined") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	config := &settings.Settings{}
+	overrides := []string{
+		"etcd.prefix=beacon",
+		"watchers.nginx.command=kill -HUP $(cat /var/run/nginx.pid), really: yes",
+		"watchers.nginx.watch=[a, b]",
+		"watchers.nginx.context=c",
+		"unknown.value=[1, 2]",
+	}
+	for _, override := range overrides {
+		if err := ConfigOverride(config, override); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if have := config.StringDflt("etcd.prefix", ""); have != "beacon" {
+		t.Errorf("etcd.prefix is '%s'", have)
+	}
+	watcher := config.ObjectMapDflt("watchers", map[string]*settings.Settings{})["nginx"]
+	if watcher == nil {
+		t.Fatal("watchers.nginx not created")
+	}
+	if have := watcher.StringDflt("command", ""); have != "kill -HUP $(cat /var/run/nginx.pid), really: yes" {
+		t.Errorf("watchers.nginx.command is '%s'", have)
+	}
+	if have := watcher.StringArrayDflt("watch", nil); !reflect.DeepEqual(have, []string{"a", "b"}) {
+		t.Errorf("watchers.nginx.watch is %v", have)
+	}
+	if have := watcher.StringDflt("context", ""); have != "c" {
+		t.Errorf("watchers.nginx.context is '%s'", have)
+	}
+
+	for _, override := range []string{"novalue", "=value", "watchers=x"} {
+		if err := ConfigOverride(config, override); err == nil {
+			t.Errorf("override '%s' did not fail", override)
+		}
+	}
+}
+
+func TestConfigCredential(t *testing.T) {
+	cred, err := ConfigCredential("root", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Uid != 0 || cred.Gid != 0 || cred.NoSetGroups {
+		t.Errorf("root credential is %+v", cred)
+	}
+	found := false
+	for _, gid := range cred.Groups {
+		found = found || gid == 0
+	}
+	if !found {
+		t.Errorf("root groups %v do not include 0", cred.Groups)
+	}
+
+	// sentinel's own groups are kept when only the group is set
+	if cred, err = ConfigCredential("", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if cred.Gid != 0 || !cred.NoSetGroups {
+		t.Errorf("group credential is %+v", cred)
+	}
+
+	if _, err := ConfigCredential("sirnotappearinginthisfilm", ""); err == nil {
+		t.Error("unknown user accepted")
+	}
+}
+
+func TestConfigWatcherAuditDiff(t *testing.T) {
+	watcher := &settings.Settings{Key: "watchers.test", Values: map[interface{}]interface{}{"command": "true"}}
+	if _, ex, err := ConfigWatcher("test", watcher); err != nil {
+		t.Fatal(err)
+	} else if !ex.(*TemplateExecutor).AuditDiff {
+		t.Error("audit diffs are not enabled by default")
+	}
+
+	watcher.Set("audit-diff", false)
+	if _, ex, err := ConfigWatcher("test", watcher); err != nil {
+		t.Fatal(err)
+	} else if ex.(*TemplateExecutor).AuditDiff {
+		t.Error("audit diffs are not disabled")
+	}
+}
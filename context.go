@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// A client which can list the keys stored on the server.
+type KeyLister interface {
+	// Recursively list the keys of the values under a group of `keys`.
+	Keys(keys []string) ([]string, error)
+}
+
+// Return the path of `key` in a context walked down to `prefix`. The path is
+// dotted and uses the names the key parts are given in the context. Return
+// false if the key is not under the prefix.
+func contextPath(prefix, key string) (string, bool) {
+	prefix = CleanPath(prefix)
+	key = CleanPath(key)
+	if prefix != "" {
+		if !strings.HasPrefix(key+"/", prefix+"/") {
+			return "", false
+		}
+		key = strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	}
+	parts := strings.Split(key, "/")
+	for n, part := range parts {
+		parts[n] = getKeyName(part)
+	}
+	return strings.Join(parts, "."), true
+}
+
+// Return the context of `ex` along with a map of the raw key paths of its
+// values to their paths in the context.
+func rawContext(ex *TemplateExecutor, client Client, context interface{}) (map[string]interface{}, error) {
+	lister, ok := client.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("client does not support listing keys")
+	}
+	keys, err := lister.Keys(ex.context)
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, key := range keys {
+		if path, ok := contextPath(ex.prefix, key); ok {
+			paths[key] = path
+		}
+	}
+	return map[string]interface{}{"context": context, "keys": paths}, nil
+}
+
+// Write `value` to `out` in `format`, either json or yaml.
+func writeFormatted(out io.Writer, format string, value interface{}) error {
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		if data, err = json.MarshalIndent(value, "", "  "); err == nil {
+			data = append(data, '\n')
+		}
+	case "yaml":
+		data, err = yaml.Marshal(value)
+	default:
+		return fmt.Errorf("format '%s' is invalid, must be json or yaml", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// Write the context of the watcher named by the cli `options` to `out`. This
+// is the context its templates are rendered with.
+func DumpContext(options *Options, out io.Writer) error {
+	if err := checkArgs("context", options.Args); err != nil {
+		return err
+	}
+	_, sentinel, err := loadSentinel(options)
+	if err != nil {
+		return err
+	}
+	ex, err := sentinel.templateExecutor(options.Args[0])
+	if err != nil {
+		return err
+	}
+	return dumpContext(ex, sentinel.Client, options, out)
+}
+
+// Wait for `client` to connect. The wait is canceled on an interrupt or after
+// `timeout` unless it is zero.
+func waitClient(client Client, timeout time.Duration) error {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	stop := make(chan bool)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+		case <-expired:
+		case <-done:
+			return
+		}
+		close(stop)
+	}()
+	if !client.Wait(stop) {
+		return fmt.Errorf("client is not available")
+	}
+	return nil
+}
+
+// Write the context of `ex` to `out` as given by the cli `options`.
+func dumpContext(ex *TemplateExecutor, client Client, options *Options, out io.Writer) error {
+	if err := waitClient(client, options.Timeout); err != nil {
+		return err
+	}
+	context, err := ex.getContext(client)
+	if err != nil {
+		return err
+	}
+	var value interface{} = context
+	if options.Raw {
+		if value, err = rawContext(ex, client, context); err != nil {
+			return err
+		}
+	}
+	return writeFormatted(out, options.Format, value)
+}
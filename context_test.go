@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestContextPath(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		path   string
+		ok     bool
+	}{
+		{"sentinel", "/sentinel/upstream-hosts/web-1", "upstream_hosts.web_1", true},
+		{"/sentinel/", "/sentinel/value", "value", true},
+		{"sentinel", "/sentinel-other/value", "", false},
+		{"", "/a/b", "a.b", true},
+	}
+	for _, test := range tests {
+		path, ok := contextPath(test.prefix, test.key)
+		if path != test.path || ok != test.ok {
+			t.Errorf("contextPath(%q, %q) = %q, %v", test.prefix, test.key, path, ok)
+		}
+	}
+}
+
+func TestDumpContext(t *testing.T) {
+	client := &MockClient{
+		GetValue: map[string]interface{}{
+			"sentinel": map[string]interface{}{
+				"upstream_hosts": map[string]interface{}{"web_1": "10.0.0.1"},
+			},
+		},
+		KeyValue: []string{"/sentinel/upstream-hosts/web-1"},
+	}
+	ex := &TemplateExecutor{
+		name:    "test",
+		prefix:  "sentinel",
+		context: []string{"sentinel/upstream-hosts"},
+	}
+
+	tests := []struct {
+		options *Options
+		want    string
+	}{
+		{&Options{Format: "json"}, "{\n  \"upstream_hosts\": {\n    \"web_1\": \"10.0.0.1\"\n  }\n}\n"},
+		{&Options{Format: "yaml"}, "upstream_hosts:\n  web_1: 10.0.0.1\n"},
+		{&Options{Format: "yaml", Raw: true}, "context:\n  upstream_hosts:\n    web_1: 10.0.0.1\n" +
+			"keys:\n  /sentinel/upstream-hosts/web-1: upstream_hosts.web_1\n"},
+	}
+	for _, test := range tests {
+		out := &bytes.Buffer{}
+		if err := dumpContext(ex, client, test.options, out); err != nil {
+			t.Error(err)
+		} else if have := out.String(); have != test.want {
+			t.Errorf("'%s' != '%s'", test.want, have)
+		}
+	}
+
+	if err := dumpContext(ex, client, &Options{Format: "xml"}, &bytes.Buffer{}); err == nil {
+		t.Error("invalid format accepted")
+	}
+
+	// the wait for an unavailable client times out
+	client.WaitFor = time.Minute
+	if err := dumpContext(ex, client, &Options{Format: "json", Timeout: 10 * time.Millisecond}, &bytes.Buffer{}); err == nil {
+		t.Error("wait did not time out")
+	}
+}
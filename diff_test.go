@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+	want := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -13,3 +13,4 @@
+ 13
+ 14
+ 15
++16
+`
+	if have := UnifiedDiff("a", "b", a, b); have != want {
+		t.Errorf("unexpected diff:\n%s", have)
+	}
+}
+
+func TestUnifiedDiffNewFile(t *testing.T) {
+	want := `--- a
++++ b
+@@ -0,0 +1,2 @@
++x
++y
+\ No newline at end of file
+`
+	if have := UnifiedDiff("a", "b", "", "x\ny"); have != want {
+		t.Errorf("unexpected diff:\n%s", have)
+	}
+	if have := UnifiedDiff("a", "b", "x\n", "x\n"); have != "" {
+		t.Errorf("unexpected diff:\n%s", have)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+)
+
+// The prefix of environment variables which set config values.
+const EnvPrefix = "SENTINEL_"
+
+// Look up the value of an environment variable. Return false if it is not set.
+type EnvLookup func(name string) (string, bool)
+
+// Expand `${VAR}` and `${VAR:-default}` references in `value` using `lookup`.
+// The default is used when the variable is unset or empty. A literal `${` may
+// be written as `$${`. Return an error if a variable without a default is
+// unset or a reference is not terminated.
+func ExpandEnv(value string, lookup EnvLookup) (string, error) {
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if strings.HasPrefix(value[i:], "$${") {
+			out = append(out, "${"...)
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			out = append(out, value[i])
+			continue
+		}
+
+		end := strings.Index(value[i:], "}")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated reference in '%s'", value)
+		}
+		ref := value[i+2 : i+end]
+		name, dflt, hasDflt := ref, "", false
+		if n := strings.Index(ref, ":-"); n != -1 {
+			name, dflt, hasDflt = ref[:n], ref[n+2:], true
+		}
+
+		if envValue, ok := lookup(name); ok && (envValue != "" || !hasDflt) {
+			out = append(out, envValue...)
+		} else if hasDflt {
+			out = append(out, dflt...)
+		} else {
+			return "", fmt.Errorf("variable %s is not set", name)
+		}
+		i += end
+	}
+	return string(out), nil
+}
+
+// Return the environment variable which sets the config value at `path`.
+func ConfigEnvName(path string) string {
+	mapping := func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}
+	return EnvPrefix + strings.Map(mapping, strings.ToUpper(path))
+}
+
+// Call `visit` for each value in `config` described by `schema`. The value is
+// found at `key` in the settings object passed to `visit`. Values in objects
+// are visited whether or not they are set. Values in maps and lists are only
+// visited for the items which exist. The `path` is the full key path of the
+// value.
+func walkConfig(config *settings.Settings, key, path string, schema *configSchema, visit func(config *settings.Settings, key, path string, schema *configSchema) error) error {
+	switch schema.Type {
+	case typeObject:
+		for name, field := range schema.Fields {
+			if err := walkConfig(config, joinConfigPath(key, name), joinConfigPath(path, name), field, visit); err != nil {
+				return err
+			}
+		}
+	case typeMap:
+		if !schema.Elem.container() {
+			// maps of single values are visited by key
+			value, _ := config.Raw(key)
+			items, _ := normalizeYAML(value).(map[string]interface{})
+			for name := range items {
+				if err := visit(config, joinConfigPath(key, name), joinConfigPath(path, name), schema.Elem); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for name, item := range config.ObjectMapDflt(key, map[string]*settings.Settings{}) {
+			if err := walkConfig(item, "", joinConfigPath(path, name), schema.Elem, visit); err != nil {
+				return err
+			}
+		}
+	case typeList:
+		items, _ := config.ObjectArray(key)
+		for n, item := range items {
+			if err := walkConfig(item, "", joinConfigPath(path, fmt.Sprint(n)), schema.Elem, visit); err != nil {
+				return err
+			}
+		}
+	default:
+		return visit(config, key, path, schema)
+	}
+	return nil
+}
+
+// Expand environment variable references in the string values of `config`.
+// Values whose schema is marked `Raw` are left as is.
+func ConfigExpandEnv(config *settings.Settings, lookup EnvLookup) error {
+	return configExpandEnv(config, ConfigSchema, lookup)
+}
+
+// Expand environment variable references in the values of `config` described
+// by `schema`.
+func configExpandEnv(config *settings.Settings, schema *configSchema, lookup EnvLookup) error {
+	return walkConfig(config, "", "", schema, func(config *settings.Settings, key, path string, schema *configSchema) error {
+		if schema.Raw {
+			return nil
+		}
+		if value, err := config.String(key); err == nil {
+			expanded, err := ExpandEnv(value, lookup)
+			if err != nil {
+				return fmt.Errorf("config '%s' is invalid: %s", path, err)
+			} else if expanded == value {
+				return nil
+			}
+			typed, err := parseConfigValue(schema, expanded)
+			if err != nil {
+				return fmt.Errorf("config '%s' is invalid: %s", path, err)
+			}
+			config.Set(key, typed)
+		} else if values, err := config.StringArray(key); err == nil && schema.Type == typeStrings {
+			expanded := make([]string, len(values))
+			for n, value := range values {
+				if expanded[n], err = ExpandEnv(value, lookup); err != nil {
+					return fmt.Errorf("config '%s' is invalid: %s", path, err)
+				}
+			}
+			config.Set(key, expanded)
+		}
+		return nil
+	})
+}
+
+// Set config values from `SENTINEL_*` environment variables. The variable name
+// is the upper cased key path with dots and dashes replaced by underscores.
+// For example `etcd.tls-key` is set by `SENTINEL_ETCD_TLS_KEY`. Lists are
+// given as comma separated values or in YAML flow style, e.g. `[a, b]`. Raw
+// values such as commands are never split on commas; they are set as a single
+// string unless given in flow style.
+func ConfigEnv(config *settings.Settings, lookup EnvLookup) error {
+	return configEnv(config, ConfigSchema, lookup)
+}
+
+// Set the values of `config` described by `schema` from `SENTINEL_*`
+// environment variables.
+func configEnv(config *settings.Settings, schema *configSchema, lookup EnvLookup) error {
+	return walkConfig(config, "", "", schema, func(config *settings.Settings, key, path string, schema *configSchema) error {
+		name := ConfigEnvName(path)
+		value, ok := lookup(name)
+		if !ok {
+			return nil
+		}
+
+		switch schema.Type {
+		case typeStrings:
+			if strings.HasPrefix(strings.TrimSpace(value), "[") {
+				var items []interface{}
+				if err := yaml.Unmarshal([]byte(value), &items); err != nil {
+					return fmt.Errorf("environment variable %s is invalid: %s", name, err)
+				}
+				config.Set(key, items)
+			} else if schema.Raw {
+				config.Set(key, value)
+			} else {
+				config.Set(key, strings.Split(value, ","))
+			}
+		default:
+			parsed, err := parseConfigValue(schema, value)
+			if err != nil {
+				return fmt.Errorf("environment variable %s is invalid: %s", name, err)
+			}
+			config.Set(key, parsed)
+		}
+		return nil
+	})
+}
+
+// Convert a single string `value` to the type given by `schema`. Booleans and
+// integers are parsed; all other values are kept as strings.
+func parseConfigValue(schema *configSchema, value string) (interface{}, error) {
+	switch schema.Type {
+	case typeBool:
+		return strconv.ParseBool(value)
+	case typeInt:
+		return strconv.Atoi(value)
+	}
+	return value, nil
+}
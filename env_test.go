@@ -0,0 +1,192 @@
+package main
+
+import (
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Return an environment lookup function for the `env` map.
+func mockEnv(env map[string]string) EnvLookup {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	lookup := mockEnv(map[string]string{
+		"HOST":  "etcd.example.net",
+		"EMPTY": "",
+	})
+
+	checks := map[string]string{
+		"plain":                       "plain",
+		"http://${HOST}:4001":         "http://etcd.example.net:4001",
+		"${MISSING:-default}":         "default",
+		"${EMPTY:-default}":           "default",
+		"${EMPTY}":                    "",
+		"${HOST:-default}/${HOST}":    "etcd.example.net/etcd.example.net",
+		"$HOST $${HOST} $(cat file)":  "$HOST ${HOST} $(cat file)",
+		"${MISSING:-}":                "",
+		"${MISSING:-with:-separator}": "with:-separator",
+	}
+	for value, want := range checks {
+		if have, err := ExpandEnv(value, lookup); err != nil {
+			t.Errorf("expand '%s' failed: %s", value, err)
+		} else if have != want {
+			t.Errorf("expand '%s': '%s' != '%s'", value, want, have)
+		}
+	}
+
+	for _, value := range []string{"${MISSING}", "${HOST"} {
+		if _, err := ExpandEnv(value, lookup); err == nil {
+			t.Errorf("expand '%s' did not fail", value)
+		}
+	}
+}
+
+func TestConfigEnvName(t *testing.T) {
+	checks := map[string]string{
+		"etcd.uris":                  "SENTINEL_ETCD_URIS",
+		"etcd.tls-key":               "SENTINEL_ETCD_TLS_KEY",
+		"watchers.nginx.command":     "SENTINEL_WATCHERS_NGINX_COMMAND",
+		"watchers.my-app.watch":      "SENTINEL_WATCHERS_MY_APP_WATCH",
+		"watchers.a.templates.0.src": "SENTINEL_WATCHERS_A_TEMPLATES_0_SRC",
+	}
+	for path, want := range checks {
+		if have := ConfigEnvName(path); have != want {
+			t.Errorf("%s: %s != %s", path, want, have)
+		}
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml": `
+etcd:
+  uris: ["http://${ETCD_HOST:-localhost}:4001"]
+  prefix: file
+watchers:
+  nginx:
+    prefix: ${NGINX_PREFIX}
+    command: echo ${HOME}
+    env:
+      HOST: ${NGINX_HOST:-localhost}
+  app:
+    command: ["app"]
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := settings.Load(path.Join(dir, "sentinel.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := mockEnv(map[string]string{
+		"NGINX_PREFIX":                  "nginx",
+		"SENTINEL_ETCD_PREFIX":          "env",
+		"SENTINEL_LOGGING_LEVEL":        "debug",
+		"SENTINEL_WATCHERS_NGINX_WATCH": "a,b",
+		"SENTINEL_WATCHERS_APP_COMMAND": "kill -HUP $(cat /run/app.pid), really",
+	})
+	if err := ConfigExpandEnv(config, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigEnv(config, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if have := config.StringArrayDflt("etcd.uris", nil); !reflect.DeepEqual(have, []string{"http://localhost:4001"}) {
+		t.Errorf("etcd.uris is %v", have)
+	}
+	if have := config.StringDflt("etcd.prefix", ""); have != "env" {
+		t.Errorf("etcd.prefix is '%s'", have)
+	}
+	if have := config.StringDflt("logging.level", ""); have != "debug" {
+		t.Errorf("logging.level is '%s'", have)
+	}
+
+	watcher := config.ObjectMapDflt("watchers", nil)["nginx"]
+	if have := watcher.StringDflt("prefix", ""); have != "nginx" {
+		t.Errorf("watchers.nginx.prefix is '%s'", have)
+	}
+	if have := watcher.StringDflt("command", ""); have != "echo ${HOME}" {
+		t.Errorf("watchers.nginx.command is '%s'", have)
+	}
+	if have := watcher.StringArrayDflt("watch", nil); !reflect.DeepEqual(have, []string{"a", "b"}) {
+		t.Errorf("watchers.nginx.watch is %v", have)
+	}
+	if have := watcher.StringDflt("env.HOST", ""); have != "localhost" {
+		t.Errorf("watchers.nginx.env.HOST is '%s'", have)
+	}
+
+	// commands are not split on commas
+	app := config.ObjectMapDflt("watchers", nil)["app"]
+	if have := app.StringDflt("command", ""); have != "kill -HUP $(cat /run/app.pid), really" {
+		t.Errorf("watchers.app.command is '%s'", have)
+	}
+
+	// lists may be given in flow style
+	lookup = mockEnv(map[string]string{"SENTINEL_WATCHERS_APP_COMMAND": "[app, -c, 'a,b']"})
+	if err := ConfigEnv(config, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if have := app.StringArrayDflt("command", nil); !reflect.DeepEqual(have, []string{"app", "-c", "a,b"}) {
+		t.Errorf("watchers.app.command is %v", have)
+	}
+
+	if err := ConfigExpandEnv(config, mockEnv(map[string]string{})); err != nil {
+		t.Errorf("expand failed on expanded config: %s", err)
+	}
+	config.Set("etcd.prefix", "${MISSING}")
+	if err := ConfigExpandEnv(config, mockEnv(map[string]string{})); err == nil {
+		t.Error("expand of missing variable did not fail")
+	}
+}
+
+func TestConfigExpandEnvTyped(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"sentinel.yml": `
+on-start: "${ON_START}"
+audit:
+  max-diff: ${MAX_DIFF:-10}
+watchers:
+  nginx:
+    webhook:
+      url: http://localhost/
+      retries: ${RETRIES}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := settings.Load(path.Join(dir, "sentinel.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := mockEnv(map[string]string{
+		"ON_START": "false",
+		"RETRIES":  "3",
+	})
+	if err := ConfigExpandEnv(config, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if have, err := config.Int("audit.max-diff"); err != nil || have != 10 {
+		t.Errorf("audit.max-diff is %d: %v", have, err)
+	}
+	watcher := config.ObjectMapDflt("watchers", nil)["nginx"]
+	if have, err := watcher.Int("webhook.retries"); err != nil || have != 3 {
+		t.Errorf("watchers.nginx.webhook.retries is %d: %v", have, err)
+	}
+	if have, err := config.Raw("on-start"); err != nil || have != false {
+		t.Errorf("on-start is %v: %v", have, err)
+	}
+
+	config.Set("audit.max-diff", "${MAX_DIFF}")
+	if err := ConfigExpandEnv(config, mockEnv(map[string]string{"MAX_DIFF": "ten"})); err == nil {
+		t.Error("expand of invalid int did not fail")
+	}
+}
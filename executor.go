@@ -1,123 +1,614 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// Guards the last status of all template executors.
+var statusLock sync.Mutex
+
 // An Executor is responsible for executed to perform some action when a
 // watched key is changed.
 type Executor interface {
 	// Return the unique name of the executor.
 	Name() string
 
-	// Called to run the executor's actions.
-	Execute(client Client) error
+	// Called to run the executor's actions. The `event` is the change which
+	// triggered the execution or nil if it was not triggered by a change.
+	Execute(client Client, event *Event) error
+}
+
+// An executor which can show the changes it would make without making them.
+type DryRunner interface {
+	// Write the changes the executor would make to `out`.
+	DryRun(client Client, out io.Writer) error
+}
+
+// An executor whose execution may wait on something which can be canceled.
+type StoppableExecutor interface {
+	// Execute as Executor.Execute does. Waits are canceled when `stop` is
+	// closed.
+	ExecuteStop(client Client, event *Event, stop <-chan bool) error
 }
 
-// A Executor performs template rendering. It will optionally execute a command
-// when one or more changes are made by the templating system. If no templates
-// are provided the command will always be executed.
+// The types of watcher.
+const (
+	// Renders templates and runs a command.
+	WatcherTypeCommand = "command"
+
+	// Renders templates and calls a webhook.
+	WatcherTypeWebhook = "webhook"
+
+	// Renders templates and writes a key.
+	WatcherTypeKV = "kv"
+)
+
+// A Executor performs template rendering. It will optionally signal a process
+// or execute a command when one or more changes are made by the templating
+// system. If no templates are provided the action will always be run. The
+// command is run as a fallback if the signaled process is not running.
 type TemplateExecutor struct {
-	name      string
-	prefix    string
-	context   []string
-	Templates []Template
-	Command   []string
+	name            string
+	prefix          string
+	context         []string
+	Templates       []Template
+	Command         []string
+	CommandTemplate bool
+	Env             map[string]string
+	Dir             string
+	Stdin           bool
+	Cred            *syscall.Credential
+	Signal          *SignalConfig
+	Lock            *LockConfig
+	Leader          bool
+	Resync          time.Duration
+	AuditDiff       bool
+	last            *ExecutionStatus
 }
 
-// Render the templates. Return true if any templates changed.
-func (ex *TemplateExecutor) render(context interface{}) (changed bool, err error) {
+// Return the template executor which `executor` is or wraps.
+func asTemplateExecutor(executor Executor) (*TemplateExecutor, bool) {
+	switch ex := executor.(type) {
+	case *TemplateExecutor:
+		return ex, true
+	case *WebhookExecutor:
+		return ex.TemplateExecutor, true
+	case *KVExecutor:
+		return ex.TemplateExecutor, true
+	}
+	return nil, false
+}
+
+// Render the templates. Return true if any templates changed. The result of
+// each render is added to `status`. Changes are added to `record` if it is not
+// nil.
+func (ex *TemplateExecutor) render(context interface{}, status *ExecutionStatus, record *AuditRecord) (changed bool, err error) {
 	var oneChanged bool
 	if ex.Templates == nil || len(ex.Templates) == 0 {
-		logger.Debugf("%s: no templates to render", ex.name)
+		ex.log().Debugf("no templates to render")
 		changed = true
 		return
 	}
 
-	logger.Debugf("%s: context %+v", ex.name, context)
+	ex.log().Debugf("context %+v", logger.Redact(context))
 	for _, tpl := range ex.Templates {
-		if oneChanged, err = tpl.Render(context); err != nil {
+		var content []byte
+		oneChanged = false
+		if content, err = tpl.render(context); err == nil {
+			if oneChanged = tpl.compare(content); oneChanged {
+				if record != nil {
+					record.addChange(tpl.Dest, tpl.current(), content)
+				}
+				err = tpl.install(content)
+			}
+		}
+		tplStatus := TemplateStatus{Src: tpl.Src, Dest: tpl.Dest, Changed: oneChanged}
+		if err != nil {
+			tplStatus.Error = err.Error()
+			renders.Inc(ex.name, "error")
+		} else if oneChanged {
+			renders.Inc(ex.name, "changed")
+		} else {
+			renders.Inc(ex.name, "unchanged")
+		}
+		status.Templates = append(status.Templates, tplStatus)
+		if err != nil {
 			return
 		}
+		tplLog := ex.log().With(Fields{"template": tpl.Src, "dest": tpl.Dest})
 		if oneChanged {
-			logger.Debugf("%s: rendered '%s' -> '%s'", ex.name, tpl.Src, tpl.Dest)
+			tplLog.Debugf("rendered '%s' -> '%s'", tpl.Src, tpl.Dest)
 		} else {
-			logger.Debugf("%s: no change to '%s'", ex.name, tpl.Dest)
+			tplLog.Debugf("no change to '%s'", tpl.Dest)
 		}
 		changed = changed || oneChanged
 	}
 	return
 }
 
-// Run the command.
-func (ex *TemplateExecutor) run() error {
+// An action which runs after the templates of an executor change. The
+// `context` is the one the templates were rendered with. The result of the
+// action is stored in `status`.
+type action func(client Client, event *Event, context interface{}, status *ExecutionStatus) error
+
+// Run the command. A wait for the lock is canceled by `stop`. The result is
+// stored in `status`.
+func (ex *TemplateExecutor) run(client Client, event *Event, context interface{}, status *ExecutionStatus, stop <-chan bool) error {
+	if ex.Signal != nil {
+		if sent, err := ex.signal(status); sent || err != nil {
+			return err
+		}
+		if len(ex.Command) > 0 {
+			ex.log().Infof("%s is not running, running command instead", ex.Signal.Target())
+		}
+	}
 	if len(ex.Command) == 0 {
-		logger.Debugf("%s: no command to call", ex.name)
+		ex.log().Debugf("no command to call")
 		return nil
 	}
 
-	cmdName := ex.Command[0]
-	cmdArgs := ex.Command[1:]
-	command := exec.Command(cmdName, cmdArgs...)
+	var lost <-chan bool
+	var record *LockRecord
+	if ex.Lock != nil {
+		lock := NewLock(client, ex.Lock.Key, ex.Lock.TTL)
+		var acquired bool
+		var err error
+		if ex.Lock.Wait {
+			acquired, err = lock.Acquire(stop)
+		} else {
+			acquired, err = lock.TryAcquire()
+		}
+		if err != nil {
+			return fmt.Errorf("lock %s failed: %s", ex.Lock.Key, err)
+		}
+		if !acquired && ex.Lock.Wait {
+			ex.log().Infof("wait for lock %s was canceled, skipping command", ex.Lock.Key)
+			status.Skipped = "lock wait canceled"
+			return nil
+		} else if !acquired {
+			ex.log().Infof("lock %s is held elsewhere, skipping command", ex.Lock.Key)
+			status.Skipped = "lock held elsewhere"
+			return nil
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				ex.log().Errorf("lock %s release failed: %s", ex.Lock.Key, err)
+			}
+		}()
+		lost = lock.Lost()
+
+		if record, err = ex.claim(client, event, context); err != nil {
+			return err
+		} else if record == nil {
+			ex.log().Infof("change was handled elsewhere, skipping command")
+			status.Skipped = "change handled elsewhere"
+			return nil
+		}
+	}
+
+	command, err := ex.command(event, context, status)
+	if err != nil {
+		ex.log().Errorf("command %v failed: %s", ex.Command, err)
+		status.Command = &CommandStatus{
+			Command: ex.Command,
+			Error:   err.Error(),
+		}
+		commandFailures.Inc(ex.name)
+		return err
+	}
+	out := &bytes.Buffer{}
+	command.Stdout = out
+	command.Stderr = out
 
-	out, err := command.CombinedOutput()
+	start := time.Now()
+	err = command.Start()
+	if err == nil {
+		err = ex.wait(command, lost)
+	}
+	commandDuration.ObserveSince(start, ex.name)
+	status.Command = &CommandStatus{
+		Command: command.Args,
+		Output:  out.String(),
+	}
+	if command.ProcessState != nil {
+		if waitStatus, ok := command.ProcessState.Sys().(syscall.WaitStatus); ok {
+			status.Command.ExitCode = waitStatus.ExitStatus()
+		}
+	}
+	cmdLog := ex.log().With(Fields{
+		"command":   command.Args,
+		"exit_code": status.Command.ExitCode,
+		"duration":  time.Since(start).Seconds(),
+	})
 	if err == nil {
-		logger.Debugf("%s: command %v ran", ex.name, ex.Command)
+		cmdLog.Debugf("command %v ran", command.Args)
 	} else {
-		logger.Errorf("%s: command %v failed: %s", ex.name, ex.Command, err)
-	}
-	outStr := string(out)
-	if outStr != "" {
-		lines := strings.Split(outStr, "\n")
-		for _, line := range lines {
-			if err == nil {
-				logger.Debugf("%s> %s", ex.name, line)
-			} else {
-				logger.Errorf("%s> %s", ex.name, line)
-			}
+		cmdLog.Errorf("command %v failed: %s", command.Args, err)
+		status.Command.Error = err.Error()
+		commandFailures.Inc(ex.name)
+	}
+	ex.logOutput(out.String(), err != nil)
+	if err == nil && record != nil {
+		if err := record.Save(client, ex.Lock.RecordKey()); err != nil {
+			ex.log().Errorf("lock record %s save failed: %s", ex.Lock.RecordKey(), err)
 		}
 	}
 	return err
 }
 
+// Check the record of the last run made under the lock. Return the record to
+// save after running the command or nil if the run was already made elsewhere.
+// Must be called with the lock held.
+func (ex *TemplateExecutor) claim(client Client, event *Event, context interface{}) (*LockRecord, error) {
+	last, err := LoadLockRecord(client, ex.Lock.RecordKey())
+	if err != nil {
+		return nil, err
+	}
+	hash, err := contextHash(context)
+	if err != nil {
+		return nil, err
+	}
+	if last.Handled(event, hash) {
+		return nil, nil
+	}
+	return last.Next(event, hash), nil
+}
+
+// Return the environment of the command. The watcher's environment is added to
+// Sentinel's and followed by variables describing the `event` and the files
+// changed in `status`.
+func (ex *TemplateExecutor) environ(event *Event, status *ExecutionStatus) []string {
+	env := os.Environ()
+	names := make([]string, 0, len(ex.Env))
+	for name := range ex.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+ex.Env[name])
+	}
+
+	changed := changedFiles(status)
+	key, action := "", ""
+	if event != nil {
+		key, action = event.Key, event.Action
+	}
+	return append(env,
+		"SENTINEL_WATCHER="+ex.name,
+		"SENTINEL_KEY="+key,
+		"SENTINEL_ACTION="+action,
+		"SENTINEL_CHANGED_FILES="+strings.Join(changed, " "),
+	)
+}
+
+// Return the template destinations which changed in `status`.
+func changedFiles(status *ExecutionStatus) []string {
+	changed := []string{}
+	for _, tpl := range status.Templates {
+		if tpl.Changed {
+			changed = append(changed, tpl.Dest)
+		}
+	}
+	return changed
+}
+
+// Create the command to run for the `event`. Each argument is rendered as a
+// template if enabled. The `context` is passed on stdin as JSON if enabled.
+func (ex *TemplateExecutor) command(event *Event, context interface{}, status *ExecutionStatus) (*exec.Cmd, error) {
+	data := &CommandData{
+		Watcher: ex.name,
+		Context: context,
+		Event:   event,
+		Changed: changedFiles(status),
+	}
+	if data.Event == nil {
+		data.Event = &Event{}
+	}
+	args := ex.Command
+	if ex.CommandTemplate {
+		var err error
+		if args, err = RenderCommand(ex.Command, data); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) == 0 || args[0] == "" {
+		return nil, fmt.Errorf("command is empty")
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	command.Env = ex.environ(event, status)
+	command.Dir = ex.Dir
+	if ex.Cred != nil {
+		command.SysProcAttr = &syscall.SysProcAttr{Credential: ex.Cred}
+	}
+	if ex.Stdin {
+		data, err := json.Marshal(context)
+		if err != nil {
+			return nil, fmt.Errorf("context encoding failed: %s", err)
+		}
+		command.Stdin = bytes.NewReader(data)
+	}
+	return command, nil
+}
+
+// Send the signal. The result is stored in `status`. Return true if a process
+// was signaled or false if the target is not running.
+func (ex *TemplateExecutor) signal(status *ExecutionStatus) (bool, error) {
+	status.Signal = &SignalStatus{Signal: ex.Signal.Name, Target: ex.Signal.Target()}
+	sigLog := ex.log().With(Fields{"signal": ex.Signal.Name, "target": ex.Signal.Target()})
+	pids, err := ex.Signal.Send()
+	status.Signal.PIDs = pids
+	if err != nil {
+		sigLog.Errorf("signal failed: %s", err)
+		status.Signal.Error = err.Error()
+		return false, err
+	}
+	if len(pids) == 0 {
+		sigLog.Infof("%s is not running, %s not sent", ex.Signal.Target(), ex.Signal.Name)
+		status.Signal.Error = "target is not running"
+		if len(ex.Command) == 0 {
+			status.Skipped = "signal target is not running"
+		}
+		return false, nil
+	}
+	sigLog.Debugf("sent %s to %v", ex.Signal.Name, pids)
+	return true, nil
+}
+
+// Wait for a started command to exit. The command is killed if `lost` is
+// closed before it exits.
+func (ex *TemplateExecutor) wait(command *exec.Cmd, lost <-chan bool) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-lost:
+		command.Process.Kill()
+		<-done
+		return fmt.Errorf("lock %s was lost, command killed", ex.Lock.Key)
+	}
+}
+
+// Log the output of the command. Text logs contain one record per line. JSON
+// logs contain a single record with the output in the `output` field.
+func (ex *TemplateExecutor) logOutput(out string, failed bool) {
+	if out == "" {
+		return
+	}
+	logf := func(entry *LogEntry, format string, a ...interface{}) {
+		if failed {
+			entry.Errorf(format, a...)
+		} else {
+			entry.Debugf(format, a...)
+		}
+	}
+	if logger.IsJSON() {
+		logf(ex.log().With(Fields{"output": out}), "command output")
+		return
+	}
+	for _, line := range strings.Split(out, "\n") {
+		logf(logger.With(nil), "%s> %s", ex.name, line)
+	}
+}
+
+// Return a log entry for the executor.
+func (ex *TemplateExecutor) log() *LogEntry {
+	return logger.With(Fields{"watcher": ex.name})
+}
+
+// Return true if the executor only runs on the elected leader.
+func (ex *TemplateExecutor) LeaderOnly() bool {
+	return ex.Leader
+}
+
+// Return how often the executor is run periodically.
+func (ex *TemplateExecutor) Interval() time.Duration {
+	return ex.Resync
+}
+
 // Return the unique name of the executor.
 func (ex *TemplateExecutor) Name() string {
 	return ex.name
 }
 
+// Retrieve the context keys from the `client`.
+func (ex *TemplateExecutor) get(client Client) (map[string]interface{}, error) {
+	start := time.Now()
+	defer getDuration.ObserveSince(start, ex.name)
+	return client.Get(ex.context)
+}
+
+// Return the status of the last run or nil if the executor has not run.
+func (ex *TemplateExecutor) Status() *ExecutionStatus {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	return ex.last
+}
+
+// Return true if `other` is a template executor with the same configuration.
+func (ex *TemplateExecutor) Equal(other Executor) bool {
+	otherEx, ok := other.(*TemplateExecutor)
+	if !ok {
+		return false
+	}
+	// the last status is not compared since it may be written while running
+	return ex.name == otherEx.name &&
+		ex.prefix == otherEx.prefix &&
+		reflect.DeepEqual(ex.context, otherEx.context) &&
+		reflect.DeepEqual(ex.Templates, otherEx.Templates) &&
+		reflect.DeepEqual(ex.Command, otherEx.Command) &&
+		ex.CommandTemplate == otherEx.CommandTemplate &&
+		reflect.DeepEqual(ex.Env, otherEx.Env) &&
+		ex.Dir == otherEx.Dir &&
+		ex.Stdin == otherEx.Stdin &&
+		reflect.DeepEqual(ex.Cred, otherEx.Cred) &&
+		reflect.DeepEqual(ex.Signal, otherEx.Signal) &&
+		reflect.DeepEqual(ex.Lock, otherEx.Lock) &&
+		ex.Leader == otherEx.Leader &&
+		ex.Resync == otherEx.Resync &&
+		ex.AuditDiff == otherEx.AuditDiff
+}
+
 // Render the templates using the context retrieved from the provided `client`
 // and execute the command. The command will be executed if one of the template
-// destinations changes or no templates are present in the Watcher.
-func (ex *TemplateExecutor) Execute(client Client) error {
-	var err error
-	var context interface{}
+// destinations changes or no templates are present in the Watcher. The
+// execution is written to the audit log if one is configured.
+func (ex *TemplateExecutor) Execute(client Client, event *Event) error {
+	return ex.ExecuteStop(client, event, nil)
+}
 
-	logger.Debugf("%s: executing", ex.name)
-	if ex.context == nil || len(ex.context) == 0 {
-		context = map[string]interface{}{}
-	} else if context, err = client.Get(ex.context); err != nil {
-		logger.Errorf("%s: context get failed: %s", ex.name, err)
-		return err
+// Execute as Execute does. A wait for the command's lock is canceled when
+// `stop` is closed.
+func (ex *TemplateExecutor) ExecuteStop(client Client, event *Event, stop <-chan bool) error {
+	return ex.executeAction(client, event, func(client Client, event *Event, context interface{}, status *ExecutionStatus) error {
+		return ex.run(client, event, context, status, stop)
+	})
+}
+
+// Render the templates and run `action` as Execute does for the command.
+func (ex *TemplateExecutor) executeAction(client Client, event *Event, action action) error {
+	status := &ExecutionStatus{
+		Time:      time.Now(),
+		Templates: []TemplateStatus{},
+	}
+	var record *AuditRecord
+	audit := getAuditLog()
+	if audit != nil {
+		record = audit.Record(ex.name, event, ex.AuditDiff)
+	}
+
+	err := ex.execute(client, event, status, record, action)
+	status.Duration = time.Since(status.Time).Seconds()
+	if err == nil {
+		lastSuccess.Set(time.Now(), ex.name)
 	} else {
-		for _, key := range strings.Split(ex.prefix, "/") {
-			if contextMap, ok := context.(map[string]interface{}); ok {
-				context, ok = contextMap[getKeyName(key)]
-				if !ok {
-					context = map[string]interface{}{}
-					break
-				}
-			} else {
-				context = map[string]interface{}{}
-				break
+		status.Error = err.Error()
+	}
+
+	if record != nil {
+		record.Duration = status.Duration
+		record.Error = status.Error
+		if status.Command != nil {
+			record.Command = status.Command.Command
+			record.ExitCode = &status.Command.ExitCode
+		}
+		if err := audit.Write(record); err != nil {
+			ex.log().Errorf("audit log write failed: %s", err)
+		}
+	}
+
+	statusLock.Lock()
+	ex.last = status
+	statusLock.Unlock()
+	return err
+}
+
+// Retrieve the context of the templates from the `client`.
+func (ex *TemplateExecutor) getContext(client Client) (interface{}, error) {
+	if ex.context == nil || len(ex.context) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	context, err := ex.get(client)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{} = context
+	for _, key := range strings.Split(ex.prefix, "/") {
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			if value, ok = valueMap[getKeyName(key)]; !ok {
+				return map[string]interface{}{}, nil
 			}
+		} else {
+			return map[string]interface{}{}, nil
 		}
 	}
+	return value, nil
+}
+
+// Render the templates and run the `action`. Results are stored in `status`
+// and changes in `record` if it is not nil.
+func (ex *TemplateExecutor) execute(client Client, event *Event, status *ExecutionStatus, record *AuditRecord, action action) error {
+	ex.log().Debugf("executing")
+	context, err := ex.getContext(client)
+	if err != nil {
+		ex.log().Errorf("context get failed: %s", err)
+		return err
+	}
 
 	run := true
-	run, err = ex.render(context)
+	run, err = ex.render(context, status, record)
 	if run && err == nil {
-		err = ex.run()
+		err = action(client, event, context, status)
 	}
 	return err
 }
+
+// Render the templates and write a diff of each changed destination to `out`
+// without modifying it. Report whether the command would have run.
+func (ex *TemplateExecutor) DryRun(client Client, out io.Writer) error {
+	changed, err := ex.dryRender(client, out)
+	if err != nil {
+		return err
+	}
+
+	if ex.Signal != nil {
+		if changed {
+			fmt.Fprintf(out, "signal would be sent: %s to %s\n", ex.Signal.Name, ex.Signal.Target())
+		} else {
+			fmt.Fprintf(out, "signal would not be sent: %s to %s\n", ex.Signal.Name, ex.Signal.Target())
+		}
+		if len(ex.Command) == 0 {
+			return nil
+		}
+	}
+	if len(ex.Command) == 0 {
+		_, err = fmt.Fprintln(out, "no command to run")
+	} else if changed {
+		_, err = fmt.Fprintf(out, "command would run: %v\n", ex.Command)
+	} else {
+		_, err = fmt.Fprintf(out, "command would not run: %v\n", ex.Command)
+	}
+	return err
+}
+
+// Render the templates without installing them and write a diff of each
+// change to `out`. Return true if any destination would change or there are
+// no templates.
+func (ex *TemplateExecutor) dryRender(client Client, out io.Writer) (bool, error) {
+	context, err := ex.getContext(client)
+	if err != nil {
+		return false, err
+	}
+
+	changed := len(ex.Templates) == 0
+	for _, tpl := range ex.Templates {
+		content, err := tpl.render(context)
+		if err != nil {
+			return false, fmt.Errorf("template %s failed: %s", tpl.Src, err)
+		}
+		if tpl.compare(content) {
+			changed = true
+			diff := UnifiedDiff(tpl.Dest, tpl.Dest+" (rendered)", string(tpl.current()), string(content))
+			if _, err := io.WriteString(out, diff); err != nil {
+				return false, err
+			}
+		} else {
+			fmt.Fprintf(out, "no change to %s\n", tpl.Dest)
+		}
+	}
+	return changed, nil
+}
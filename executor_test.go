@@ -1,28 +1,41 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 type MockExecutor struct {
 	name  string
 	Calls int
 	Error error
+	lock  sync.Mutex
 }
 
 func (ex *MockExecutor) Name() string {
 	return ex.name
 }
 
-func (ex *MockExecutor) Execute(client Client) error {
+func (ex *MockExecutor) Execute(client Client, event *Event) error {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
 	ex.Calls++
 	return ex.Error
 }
 
+func (ex *MockExecutor) Count() int {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	return ex.Calls
+}
+
 type ExecutorTestCase struct {
 	T         *testing.T
 	Client    *MockClient
@@ -73,7 +86,7 @@ func TestExecutorEmpty(t *testing.T) {
 		context: []string{"sentinel/context_a"},
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Error(err)
 	}
 }
@@ -100,7 +113,7 @@ func TestExecutorCommand(t *testing.T) {
 	}
 
 	for _, exec := range execs {
-		if err := exec.Execute(tc.Client); err != nil {
+		if err := exec.Execute(tc.Client, nil); err != nil {
 			t.Errorf("failed to execute: %s", err)
 		}
 
@@ -118,6 +131,101 @@ func TestExecutorCommand(t *testing.T) {
 	}
 }
 
+func TestExecutorCommandEnv(t *testing.T) {
+	tc := NewExecutorTestCase(t)
+	defer tc.Close()
+	tc.Client.GetValue = map[string]interface{}{
+		"sentinel": map[string]interface{}{"name": "test"},
+	}
+
+	ex := &TemplateExecutor{
+		name:    "test",
+		prefix:  "sentinel",
+		context: []string{"sentinel"},
+		Command: []string{"bash", "-c", "echo $SENTINEL_WATCHER $SENTINEL_KEY $SENTINEL_ACTION $FOO; pwd; cat"},
+		Env:     map[string]string{"FOO": "bar"},
+		Dir:     tc.Directory,
+		Stdin:   true,
+	}
+	event := &Event{Prefix: "sentinel", Key: "sentinel/name", Action: "set"}
+	if err := ex.Execute(tc.Client, event); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+
+	dir, _ := os.Getwd()
+	os.Chdir(tc.Directory)
+	pwd, _ := os.Getwd()
+	os.Chdir(dir)
+	want := fmt.Sprintf("test sentinel/name set bar\n%s\n{\"name\":\"test\"}", pwd)
+	if have := ex.Status().Command.Output; have != want {
+		t.Errorf("%q != %q", have, want)
+	}
+}
+
+func TestExecutorCommandTemplate(t *testing.T) {
+	tc := NewExecutorTestCase(t)
+	defer tc.Close()
+	tc.Client.GetValue = map[string]interface{}{
+		"sentinel": map[string]interface{}{"name": "it's me"},
+	}
+
+	ex := &TemplateExecutor{
+		name:            "test",
+		prefix:          "sentinel",
+		context:         []string{"sentinel"},
+		Command:         []string{"bash", "-c", "echo {{.Watcher}} {{shellQuote .Context.name}} {{.Event.Key}}"},
+		CommandTemplate: true,
+	}
+	event := &Event{Prefix: "sentinel", Key: "sentinel/name", Action: "set"}
+	if err := ex.Execute(tc.Client, event); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	status := ex.Status().Command
+	wantCommand := []string{"bash", "-c", `echo test 'it'\''s me' sentinel/name`}
+	if !reflect.DeepEqual(wantCommand, status.Command) {
+		t.Errorf("%v != %v", wantCommand, status.Command)
+	}
+	if want := "test it's me sentinel/name\n"; status.Output != want {
+		t.Errorf("%q != %q", status.Output, want)
+	}
+
+	// the event is empty if the run was not triggered by a change
+	if err := ex.Execute(tc.Client, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if want := "test it's me\n"; ex.Status().Command.Output != want {
+		t.Errorf("%q != %q", ex.Status().Command.Output, want)
+	}
+
+	// an invalid template fails the run
+	ex.Command = []string{"echo", "{{.Bogus"}
+	if err := ex.Execute(tc.Client, nil); err == nil {
+		t.Error("invalid command template succeeded")
+	}
+	if status := ex.Status().Command; status == nil || status.Error == "" {
+		t.Errorf("invalid command template not recorded: %+v", status)
+	}
+
+	// an empty command fails the run
+	ex.Command = []string{"{{.Event.Key}}", "arg"}
+	if err := ex.Execute(tc.Client, nil); err == nil {
+		t.Error("empty command succeeded")
+	}
+	if status := ex.Status().Command; status == nil || status.Error != "command is empty" {
+		t.Errorf("empty command not recorded: %+v", status)
+	}
+
+	// arguments are used as is unless templating is enabled
+	ex.CommandTemplate = false
+	ex.Command = []string{"echo", "{{.State.Pid}}"}
+	if err := ex.Execute(tc.Client, nil); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if want := "{{.State.Pid}}\n"; ex.Status().Command.Output != want {
+		t.Errorf("%q != %q", ex.Status().Command.Output, want)
+	}
+}
+
 func TestExecutorSingleContext(t *testing.T) {
 	tc := NewExecutorTestCase(t)
 	defer tc.Close()
@@ -142,7 +250,7 @@ func TestExecutorSingleContext(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 	if have, err := ioutil.ReadFile(tc.Template.Dest); err == nil {
@@ -173,7 +281,7 @@ func TestExecutorMultiContext(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 	if have, err := ioutil.ReadFile(tc.Template.Dest); err == nil {
@@ -203,7 +311,7 @@ func TestExecutorMissingContext(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 	if have, err := ioutil.ReadFile(tc.Template.Dest); err == nil {
@@ -233,7 +341,7 @@ func TestExecutorValueContext(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 	if have, err := ioutil.ReadFile(tc.Template.Dest); err == nil {
@@ -266,7 +374,7 @@ func TestExecutorChanged(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 
@@ -280,7 +388,7 @@ func TestExecutorChanged(t *testing.T) {
 	}
 
 	os.Remove(out)
-	if err := exec.Execute(tc.Client); err != nil {
+	if err := exec.Execute(tc.Client, nil); err != nil {
 		t.Errorf("failed to execute: %s", err)
 	}
 
@@ -288,3 +396,74 @@ func TestExecutorChanged(t *testing.T) {
 		t.Error("command executed with no template change")
 	}
 }
+
+func TestExecutorDryRun(t *testing.T) {
+	tc := NewExecutorTestCase(t)
+	defer tc.Close()
+	out := path.Join(tc.Directory, "out")
+
+	exec := TemplateExecutor{
+		name:      "test",
+		prefix:    "sentinel",
+		context:   []string{"sentinel/context_a"},
+		Templates: []Template{tc.Template},
+		Command:   []string{"bash", "-c", "echo hello > " + out},
+	}
+	if err := ioutil.WriteFile(tc.Template.Src, []byte("value={{.context_a.value}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tc.Template.Dest, []byte("value=z\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := exec.DryRun(tc.Client, buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "--- " + tc.Template.Dest + "\n+++ " + tc.Template.Dest + " (rendered)\n" +
+		"@@ -1 +1 @@\n-value=z\n+value=a\ncommand would run: " + fmt.Sprint(exec.Command) + "\n"
+	if have := buf.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+	if have, _ := ioutil.ReadFile(tc.Template.Dest); string(have) != "value=z\n" {
+		t.Error("template destination was modified")
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("command was run")
+	}
+
+	ioutil.WriteFile(tc.Template.Dest, []byte("value=a\n"), 0600)
+	buf.Reset()
+	if err := exec.DryRun(tc.Client, buf); err != nil {
+		t.Fatal(err)
+	}
+	want = "no change to " + tc.Template.Dest + "\ncommand would not run: " + fmt.Sprint(exec.Command) + "\n"
+	if have := buf.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
+
+func TestExecutorEqual(t *testing.T) {
+	newExecutor := func() *TemplateExecutor {
+		return &TemplateExecutor{
+			name:    "test",
+			prefix:  "sentinel",
+			context: []string{"sentinel"},
+			Command: []string{"echo", "test"},
+			Env:     map[string]string{"A": "1"},
+			Lock:    &LockConfig{Key: "locks/test", TTL: time.Second},
+		}
+	}
+	a, b := newExecutor(), newExecutor()
+	a.last = &ExecutionStatus{Time: time.Now()}
+	if !a.Equal(b) {
+		t.Error("executors with different statuses are not equal")
+	}
+	b.Env["A"] = "2"
+	if a.Equal(b) {
+		t.Error("executors with different env are equal")
+	}
+	if a.Equal(&WebhookExecutor{TemplateExecutor: newExecutor()}) {
+		t.Error("executors of different types are equal")
+	}
+}
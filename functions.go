@@ -119,3 +119,13 @@ func JSON(item interface{}) (interface{}, error) {
 	}
 	return data, err
 }
+
+// ShellQuote quotes each of `args` so that the shell reads it as a single
+// word and returns them joined by spaces.
+func ShellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -220,3 +220,24 @@ func TestJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestShellQuote(t *testing.T) {
+	tests := [][2]string{
+		{"", "''"},
+		{"simple", "'simple'"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$(rm -rf /)", "'$(rm -rf /)'"},
+	}
+
+	for _, test := range tests {
+		have := ShellQuote(test[0])
+		if have != test[1] {
+			t.Errorf("%s != %s", have, test[1])
+		}
+	}
+
+	if have := ShellQuote("a b", "c"); have != "'a b' 'c'" {
+		t.Errorf("%s != 'a b' 'c'", have)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The interval at which the run loop records a heartbeat.
+const heartbeatInterval = time.Second
+
+// Tracks the health and readiness of a sentinel.
+type Health struct {
+	lock      sync.Mutex
+	connected bool
+	running   bool
+	heartbeat time.Time
+	executed  map[string]bool
+	backoff   map[string]time.Time
+}
+
+// Record that the client connected to the server.
+func (h *Health) setConnected() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.connected = true
+}
+
+// Record whether the run loop is running.
+func (h *Health) setRunning(running bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.running = running
+	h.heartbeat = time.Now()
+}
+
+// Record a heartbeat from the run loop.
+func (h *Health) beat() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.heartbeat = time.Now()
+}
+
+// Record that the named executor ran successfully.
+func (h *Health) setExecuted(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.executed == nil {
+		h.executed = make(map[string]bool)
+	}
+	h.executed[name] = true
+}
+
+// Record that the watch on `prefix` is failing. The time of the first failure
+// is kept.
+func (h *Health) setBackoff(prefix string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.backoff == nil {
+		h.backoff = make(map[string]time.Time)
+	}
+	if _, ok := h.backoff[prefix]; !ok {
+		h.backoff[prefix] = time.Now()
+	}
+}
+
+// Record that the watch on `prefix` is healthy.
+func (h *Health) clearBackoff(prefix string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.backoff, prefix)
+}
+
+// Return an error if the run loop has stopped or has not recorded a heartbeat
+// within `timeout`.
+func (h *Health) Alive(timeout time.Duration) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.running {
+		if h.heartbeat.IsZero() {
+			return nil
+		}
+		return fmt.Errorf("run loop has stopped")
+	}
+	if since := time.Since(h.heartbeat); since > timeout {
+		return fmt.Errorf("run loop has not responded in %s", since)
+	}
+	return nil
+}
+
+// Return an error if the run loop is not running and so changes are not being
+// watched.
+func (h *Health) Watching() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.running {
+		return fmt.Errorf("not watching")
+	}
+	return nil
+}
+
+// Return an error if the sentinel is not ready. It is ready once the client
+// has connected and each of the executors in `names` has run successfully. It
+// is not ready while every prefix in `prefixes` has been failing for longer
+// than `backoffTimeout`.
+func (h *Health) Ready(names, prefixes []string, backoffTimeout time.Duration) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.connected {
+		return fmt.Errorf("not connected")
+	}
+
+	pending := []string{}
+	for _, name := range names {
+		if !h.executed[name] {
+			pending = append(pending, name)
+		}
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return fmt.Errorf("watchers have not executed: %v", pending)
+	}
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range prefixes {
+		since, ok := h.backoff[prefix]
+		if !ok || time.Since(since) <= backoffTimeout {
+			return nil
+		}
+	}
+	return fmt.Errorf("all watches have been failing for more than %s", backoffTimeout)
+}
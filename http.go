@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// The default time all watches may fail before the sentinel is unready.
+	DefaultBackoffTimeout = time.Minute
+
+	// The time the run loop may go without a heartbeat before it is dead.
+	aliveTimeout = 5 * time.Minute
+)
+
+// Serves the status API over HTTP.
+type HTTPServer struct {
+	sentinel       *Sentinel
+	mux            *http.ServeMux
+	BackoffTimeout time.Duration
+}
+
+// Create an HTTP server which reports on the `sentinel`.
+func NewHTTPServer(sentinel *Sentinel) *HTTPServer {
+	server := &HTTPServer{
+		sentinel:       sentinel,
+		mux:            http.NewServeMux(),
+		BackoffTimeout: DefaultBackoffTimeout,
+	}
+	server.mux.HandleFunc("/watchers", server.watchers)
+	server.mux.HandleFunc("/watchers/", server.watcher)
+	server.mux.HandleFunc("/metrics", server.metrics)
+	server.mux.HandleFunc("/healthz", server.healthz)
+	server.mux.HandleFunc("/readyz", server.readyz)
+	return server
+}
+
+// Write `value` to the response as JSON.
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(value); err != nil {
+		logger.Errorf("http: failed to write response: %s", err)
+	}
+}
+
+// Write a JSON error to the response.
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// List the status of all watchers.
+func (server *HTTPServer) watchers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, server.sentinel.Status())
+}
+
+// Show the status of a single watcher.
+func (server *HTTPServer) watcher(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/watchers/")
+	for _, status := range server.sentinel.Status() {
+		if status.Name == name {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "watcher not found")
+}
+
+// Write metrics in the Prometheus text format.
+func (server *HTTPServer) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Write(w); err != nil {
+		logger.Errorf("http: failed to write metrics: %s", err)
+	}
+}
+
+// Write the result of a health check.
+func writeCheck(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "fail", "error": err.Error()})
+	} else {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// Report whether the run loop is alive.
+func (server *HTTPServer) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeCheck(w, server.sentinel.Alive(aliveTimeout))
+}
+
+// Report whether the sentinel is connected and has executed every watcher.
+func (server *HTTPServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeCheck(w, server.sentinel.Ready(server.BackoffTimeout))
+}
+
+func (server *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// Listen on the address in `config` and serve the HTTP API in the background.
+// Return nil if no address is configured.
+func ServeHTTP(config *settings.Settings, sentinel *Sentinel) (net.Listener, error) {
+	listen := config.StringDflt("listen", "")
+	if listen == "" {
+		return nil, nil
+	}
+	backoffTimeout, err := time.ParseDuration(config.StringDflt("backoff-timeout", DefaultBackoffTimeout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("http backoff-timeout is invalid: %s", err)
+	}
+	server := NewHTTPServer(sentinel)
+	server.BackoffTimeout = backoffTimeout
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("serving http on %s", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, server); err != nil {
+			logger.Debugf("http: %s", err)
+		}
+	}()
+	return listener, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHTTPWatchers(t *testing.T) {
+	client := &MockClient{}
+	ex1 := &TemplateExecutor{
+		name:    "mock1",
+		Command: []string{"bash", "-c", "echo oops; exit 3"},
+	}
+	ex2 := &MockExecutor{name: "mock2"}
+	s := Sentinel{Client: client}
+	s.Add([]string{"1", "2"}, ex1)
+	s.Add([]string{"2"}, ex2)
+
+	event := &Event{Prefix: "2", Key: "2/a", Action: "set", Index: 42}
+	s.recordEvent(event)
+	s.handledEvent(event)
+	s.executeKey("2", nil)
+
+	server := httptest.NewServer(NewHTTPServer(&s))
+	defer server.Close()
+
+	var statuses []WatcherStatus
+	if resp, err := http.Get(server.URL + "/watchers"); err != nil {
+		t.Fatal(err)
+	} else {
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(statuses) != 2 || statuses[0].Name != "mock1" || statuses[1].Name != "mock2" {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+
+	status := statuses[0]
+	wantWatch := []WatchStatus{{"1", 0}, {"2", 42}}
+	if !reflect.DeepEqual(wantWatch, status.Watch) {
+		t.Errorf("%v != %v", wantWatch, status.Watch)
+	}
+	if status.LastTrigger == nil || status.LastKey != "2/a" {
+		t.Errorf("last trigger not recorded: %+v", status)
+	}
+	if status.LastExecution == nil || status.LastExecution.Command == nil {
+		t.Fatalf("last execution not recorded: %+v", status)
+	}
+	if code := status.LastExecution.Command.ExitCode; code != 3 {
+		t.Errorf("exit code is %d", code)
+	}
+	if out := status.LastExecution.Command.Output; out != "oops\n" {
+		t.Errorf("output is '%s'", out)
+	}
+	if statuses[1].LastExecution != nil {
+		t.Error("mock executor has an execution status")
+	}
+
+	if resp, err := http.Get(server.URL + "/watchers/mock2"); err != nil {
+		t.Fatal(err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status code is %d", resp.StatusCode)
+		}
+	}
+	if resp, err := http.Get(server.URL + "/watchers/missing"); err != nil {
+		t.Fatal(err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status code is %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestHTTPHealth(t *testing.T) {
+	client := &MockClient{}
+	s := Sentinel{Client: client, OnStart: true}
+	s.Add([]string{"1"}, &MockExecutor{name: "mock1"})
+
+	server := httptest.NewServer(NewHTTPServer(&s))
+	defer server.Close()
+
+	check := func(path string, want int) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s returned %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	if !s.Wait(make(chan bool)) {
+		t.Fatal("wait failed")
+	}
+	check("/readyz", http.StatusServiceUnavailable)
+
+	s.Execute([]string{}, nil)
+	check("/readyz", http.StatusOK)
+
+	s.health.setBackoff("1")
+	s.health.backoff["1"] = time.Now().Add(-2 * DefaultBackoffTimeout)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	s.handleEvent(&Event{Prefix: "1", Key: "1", Action: ActionReconnect})
+	check("/readyz", http.StatusOK)
+
+	s.health.setRunning(true)
+	s.health.heartbeat = time.Now().Add(-2 * aliveTimeout)
+	check("/healthz", http.StatusServiceUnavailable)
+	s.health.setRunning(false)
+	check("/healthz", http.StatusServiceUnavailable)
+}
+
+func TestHTTPReadyWithoutOnStart(t *testing.T) {
+	s := Sentinel{Client: &MockClient{}, OnStart: false}
+	s.Add([]string{"1"}, &MockExecutor{name: "mock1"})
+	if !s.Wait(make(chan bool)) {
+		t.Fatal("wait failed")
+	}
+
+	// watchers which never ran are ready once the watches are running
+	if err := s.Ready(DefaultBackoffTimeout); err == nil {
+		t.Error("ready before watching")
+	}
+	s.health.setRunning(true)
+	if err := s.Ready(DefaultBackoffTimeout); err != nil {
+		t.Errorf("not ready while watching: %s", err)
+	}
+
+	s.OnStart = true
+	if err := s.Ready(DefaultBackoffTimeout); err == nil {
+		t.Error("ready before watchers executed")
+	}
+}
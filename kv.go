@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Configures the key a kv watcher writes to.
+type KVConfig struct {
+	// The key to write to.
+	Key string
+
+	// The template whose output is written. Only the source is used.
+	Template Template
+
+	// How long the key lives after it is written. Zero never expires.
+	TTL time.Duration
+}
+
+// Retrieve the value of `key` from the `client`. Return false if the key does
+// not exist.
+func getValue(client Client, key string) (string, bool, error) {
+	values, err := client.Get([]string{key})
+	if err != nil {
+		return "", false, err
+	}
+	var value interface{} = values
+	for _, part := range strings.Split(CleanPath(key), "/") {
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false, nil
+		}
+		if value, ok = valueMap[getKeyName(part)]; !ok {
+			return "", false, nil
+		}
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", false, fmt.Errorf("key %s is a directory", key)
+	}
+	return str, true, nil
+}
+
+// An executor which renders a template and writes the output to a key. The key
+// is only written when its value changes so that a watcher on the key does not
+// loop. The key is deleted if the output is empty. Templates are rendered to
+// files as in a TemplateExecutor and the key is only written if one of their
+// destinations changes or no templates are present.
+type KVExecutor struct {
+	*TemplateExecutor
+	KV *KVConfig
+}
+
+// Render the value of the key. Return the rendered value, the current value,
+// and whether the key exists.
+func (ex *KVExecutor) values(client Client, context interface{}) (string, string, bool, error) {
+	content, err := ex.KV.Template.render(context)
+	if err != nil {
+		return "", "", false, fmt.Errorf("template %s failed: %s", ex.KV.Template.Src, err)
+	}
+	current, exists, err := getValue(client, ex.KV.Key)
+	if err != nil {
+		return "", "", false, err
+	}
+	return string(content), current, exists, nil
+}
+
+// Write the rendered template to the key if it changed. The result is stored
+// in `status`.
+func (ex *KVExecutor) write(client Client, event *Event, context interface{}, status *ExecutionStatus) (err error) {
+	status.KV = &KVStatus{Key: ex.KV.Key}
+	kvLog := ex.log().With(Fields{"key": ex.KV.Key})
+	defer func() {
+		if err != nil {
+			kvLog.Errorf("write to '%s' failed: %s", ex.KV.Key, err)
+			status.KV.Error = err.Error()
+		}
+	}()
+
+	value, current, exists, err := ex.values(client, context)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(value) == "" {
+		if !exists {
+			kvLog.Debugf("no change to '%s'", ex.KV.Key)
+			return
+		}
+		if err = client.Delete(ex.KV.Key); err == nil {
+			status.KV.Deleted = true
+			kvLog.Debugf("deleted '%s'", ex.KV.Key)
+		}
+		return
+	}
+	changed := !exists || value != current
+	if !changed && ex.KV.TTL == 0 {
+		kvLog.Debugf("no change to '%s'", ex.KV.Key)
+		return
+	}
+	// an unchanged key with a TTL is rewritten so that it does not expire
+	if err = client.Set(ex.KV.Key, value, ex.KV.TTL); err == nil {
+		status.KV.Changed = changed
+		if changed {
+			kvLog.Debugf("wrote '%s'", ex.KV.Key)
+		} else {
+			kvLog.Debugf("refreshed '%s'", ex.KV.Key)
+		}
+	}
+	return
+}
+
+// Render the templates using the context retrieved from the provided `client`
+// and write the key if they changed. The execution is written to the audit log
+// if one is configured.
+func (ex *KVExecutor) Execute(client Client, event *Event) error {
+	return ex.executeAction(client, event, ex.write)
+}
+
+// Execute as Execute does. Nothing the kv watcher waits on is canceled by `stop`.
+func (ex *KVExecutor) ExecuteStop(client Client, event *Event, stop <-chan bool) error {
+	return ex.Execute(client, event)
+}
+
+// Show the changes to the templates and the key. Write the changes to `out`.
+func (ex *KVExecutor) DryRun(client Client, out io.Writer) error {
+	changed, err := ex.dryRender(client, out)
+	if err != nil {
+		return err
+	}
+	context, err := ex.getContext(client)
+	if err != nil {
+		return err
+	}
+	value, current, exists, err := ex.values(client, context)
+	if err != nil {
+		return err
+	}
+
+	empty := strings.TrimSpace(value) == ""
+	switch {
+	case !changed:
+		_, err = fmt.Fprintf(out, "key would not be written: %s\n", ex.KV.Key)
+	case empty && exists:
+		_, err = fmt.Fprintf(out, "key would be deleted: %s\n", ex.KV.Key)
+	case empty || (exists && value == current):
+		_, err = fmt.Fprintf(out, "no change to key %s\n", ex.KV.Key)
+	default:
+		_, err = io.WriteString(out, UnifiedDiff(ex.KV.Key, ex.KV.Key+" (rendered)", current, value))
+	}
+	return err
+}
+
+// Return true if two executors are configured the same.
+func (ex *KVExecutor) Equal(other Executor) bool {
+	otherEx, ok := other.(*KVExecutor)
+	if !ok {
+		return false
+	}
+	return ex.TemplateExecutor.Equal(otherEx.TemplateExecutor) && reflect.DeepEqual(ex.KV, otherEx.KV)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestKVExecutor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel-kv-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := path.Join(dir, "port.tpl")
+	if err := ioutil.WriteFile(src, []byte("{{.port}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := map[string]interface{}{"port": "80"}
+	client := &MockClient{GetValue: map[string]interface{}{"app": app}}
+	ex := &KVExecutor{
+		TemplateExecutor: &TemplateExecutor{name: "kv", prefix: "app", context: []string{"app"}},
+		KV:               &KVConfig{Key: "derived/app-port", Template: Template{Src: src}},
+	}
+
+	// a missing key is written
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := client.Values["derived/app-port"]; !ok || value.Value != "80" {
+		t.Errorf("key not written: %v", value)
+	}
+	if status := ex.Status(); status.KV == nil || !status.KV.Changed {
+		t.Errorf("status does not report the write: %+v", status.KV)
+	}
+
+	// an unchanged key is not written
+	client.GetValue["derived"] = map[string]interface{}{"app_port": "80"}
+	client.Values["derived/app-port"].Value = "unchanged"
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if value := client.Values["derived/app-port"]; value.Value != "unchanged" {
+		t.Errorf("unchanged key was written: %s", value.Value)
+	}
+	if status := ex.Status(); status.KV == nil || status.KV.Changed {
+		t.Errorf("status reports a write: %+v", status.KV)
+	}
+
+	// an unchanged key with a TTL is refreshed
+	ex.KV.TTL = time.Minute
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if value := client.Values["derived/app-port"]; value.Value != "80" || value.Expires.IsZero() {
+		t.Errorf("key with a ttl not refreshed: %+v", value)
+	}
+	if status := ex.Status(); status.KV == nil || status.KV.Changed {
+		t.Errorf("status reports a change: %+v", status.KV)
+	}
+	ex.KV.TTL = 0
+
+	// an empty value deletes the key
+	app["port"] = ""
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Values["derived/app-port"]; ok {
+		t.Error("key not deleted")
+	}
+	if status := ex.Status(); status.KV == nil || !status.KV.Deleted {
+		t.Errorf("status does not report the delete: %+v", status.KV)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The default key to elect a leader at.
+const DefaultLeaderKey = "leader"
+
+// An executor which only runs on the elected leader.
+type LeaderOnlyExecutor interface {
+	// Return true if the executor only runs on the leader.
+	LeaderOnly() bool
+}
+
+// Return true if `executor` only runs on the elected leader.
+func isLeaderOnly(executor Executor) bool {
+	leaderOnly, ok := executor.(LeaderOnlyExecutor)
+	return ok && leaderOnly.LeaderOnly()
+}
+
+// Elects a leader among Sentinel instances. The leader holds a lock on a key
+// in the backend. Other instances try to take the lock until it is released
+// or expires.
+type Elector struct {
+	lock   *Lock
+	ttl    time.Duration
+	mutex  sync.Mutex
+	leader bool
+}
+
+// Create an elector which holds leadership through `key`. Leadership is lost
+// if the key is not refreshed within `ttl`.
+func NewElector(client Client, key string, ttl time.Duration) *Elector {
+	return &Elector{lock: NewLock(client, key, ttl), ttl: ttl}
+}
+
+// Return true if `other` holds leadership through the same key and ttl.
+func (e *Elector) Equal(other *Elector) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return e.lock.key == other.lock.key && e.ttl == other.ttl
+}
+
+// Return true if this instance is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.leader = leader
+}
+
+// Take part in the election until `stop` is closed. A value is sent to
+// `elected` each time this instance becomes the leader. Leadership is
+// released on stop.
+func (e *Elector) Run(elected chan bool, stop chan bool) {
+	defer func() {
+		e.setLeader(false)
+		if err := e.lock.Release(); err != nil {
+			logger.Errorf("leadership release failed: %s", err)
+		}
+	}()
+
+	for {
+		ok, err := e.lock.TryAcquire()
+		if err != nil {
+			logger.Errorf("leader election failed: %s", err)
+		}
+		if !ok {
+			select {
+			case <-time.After(e.ttl / 3):
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		logger.Infof("elected leader on %s", e.lock.key)
+		e.setLeader(true)
+		select {
+		case elected <- true:
+		case <-stop:
+			return
+		}
+
+		select {
+		case <-e.lock.Lost():
+			logger.Errorf("lost leadership on %s", e.lock.key)
+			e.setLeader(false)
+			e.lock.Release()
+		case <-stop:
+			return
+		}
+	}
+}
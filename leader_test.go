@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type MockLeaderExecutor struct {
+	MockExecutor
+}
+
+func (ex *MockLeaderExecutor) LeaderOnly() bool {
+	return true
+}
+
+func TestSentinelLeaderOnly(t *testing.T) {
+	ttl := 60 * time.Millisecond
+	client := &MockClient{}
+	ex1 := &MockLeaderExecutor{MockExecutor: MockExecutor{name: "leader"}}
+	ex2 := &MockLeaderExecutor{MockExecutor: MockExecutor{name: "leader"}}
+	s1 := Sentinel{Client: client, Elector: NewElector(client, "leader", ttl)}
+	s2 := Sentinel{Client: client, Elector: NewElector(client, "leader", ttl)}
+	s1.Add([]string{"1"}, ex1)
+	s2.Add([]string{"1"}, ex2)
+
+	// nothing runs before an election
+	if !s1.Execute([]string{}, nil) || ex1.Count() != 0 {
+		t.Fatal("leader only executor ran before election")
+	}
+
+	stop1 := make(chan bool)
+	done1 := make(chan bool)
+	go func() {
+		s1.Run(stop1)
+		close(done1)
+	}()
+	time.Sleep(ttl)
+	stop2 := make(chan bool)
+	done2 := make(chan bool)
+	go func() {
+		s2.Run(stop2)
+		close(done2)
+	}()
+	time.Sleep(ttl)
+
+	if !s1.Elector.IsLeader() || s2.Elector.IsLeader() {
+		t.Fatal("first sentinel was not elected")
+	}
+	if ex1.Count() != 1 || ex2.Count() != 0 {
+		t.Errorf("unexpected calls: %d, %d", ex1.Count(), ex2.Count())
+	}
+	s2.executeKey("1", nil)
+	if ex2.Count() != 0 {
+		t.Error("follower executed a leader only executor")
+	}
+
+	// leadership moves when the leader stops
+	stop1 <- true
+	<-done1
+	time.Sleep(ttl)
+	if !s2.Elector.IsLeader() {
+		t.Fatal("second sentinel was not elected")
+	}
+	if ex2.Count() != 1 {
+		t.Errorf("new leader did not execute: %d", ex2.Count())
+	}
+	stop2 <- true
+	<-done2
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Write each watcher of `sentinel` to `out` with its resolved keys, templates,
+// and command. Watch keys shared by watchers, destinations written by more
+// than one watcher, and destinations written twice by one watcher are flagged.
+func listWatchers(sentinel *Sentinel, out io.Writer) {
+	sentinel.lock.RLock()
+	defer sentinel.lock.RUnlock()
+
+	names := make([]string, 0, len(sentinel.executorsByName))
+	for name := range sentinel.executorsByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	watchKeys := map[string][]string{}
+	watchers := map[string][]string{}
+	for key, executors := range sentinel.executorsByKey {
+		seen := map[string]bool{}
+		for _, executor := range executors {
+			name := executor.Name()
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			watchKeys[name] = append(watchKeys[name], key)
+			watchers[key] = append(watchers[key], name)
+		}
+	}
+
+	dests := map[string][]string{}
+	warnings := []string{}
+	for _, name := range names {
+		keys := watchKeys[name]
+		sort.Strings(keys)
+		fmt.Fprintln(out, name)
+		ex, ok := asTemplateExecutor(sentinel.executorsByName[name])
+		if !ok {
+			fmt.Fprintf(out, "  watch:    %s\n", strings.Join(keys, ", "))
+			continue
+		}
+		fmt.Fprintf(out, "  prefix:   %s\n", ex.prefix)
+		fmt.Fprintf(out, "  watch:    %s\n", strings.Join(keys, ", "))
+		fmt.Fprintf(out, "  context:  %s\n", strings.Join(ex.context, ", "))
+		written := map[string]bool{}
+		for _, tpl := range ex.Templates {
+			fmt.Fprintf(out, "  template: %s -> %s\n", tpl.Src, tpl.Dest)
+			if written[tpl.Dest] {
+				warnings = append(warnings, fmt.Sprintf("warning: dest %s is written more than once by %s", tpl.Dest, name))
+				continue
+			}
+			written[tpl.Dest] = true
+			dests[tpl.Dest] = append(dests[tpl.Dest], name)
+		}
+		if ex.Signal != nil {
+			fmt.Fprintf(out, "  signal:   %s -> %s\n", ex.Signal.Name, ex.Signal.Target())
+		}
+		if len(ex.Command) > 0 {
+			fmt.Fprintf(out, "  command:  %v\n", ex.Command)
+		}
+		switch action := sentinel.executorsByName[name].(type) {
+		case *WebhookExecutor:
+			fmt.Fprintf(out, "  webhook:  %s\n", action.Webhook.URL)
+		case *KVExecutor:
+			fmt.Fprintf(out, "  kv:       %s -> %s\n", action.KV.Template.Src, action.KV.Key)
+		}
+		if ex.Resync > 0 {
+			fmt.Fprintf(out, "  interval: %s\n", ex.Resync)
+		}
+	}
+
+	for key, names := range watchers {
+		if len(names) > 1 {
+			sort.Strings(names)
+			warnings = append(warnings, fmt.Sprintf("warning: watch key %s is shared by %s", key, strings.Join(names, ", ")))
+		}
+	}
+	for dest, names := range dests {
+		if len(names) > 1 {
+			sort.Strings(names)
+			warnings = append(warnings, fmt.Sprintf("warning: dest %s is written by %s", dest, strings.Join(names, ", ")))
+		}
+	}
+	sort.Strings(warnings)
+	for _, warning := range warnings {
+		fmt.Fprintln(out, warning)
+	}
+}
+
+// Write the watchers configured by the cli `options` to `out`.
+func ListWatchers(options *Options, out io.Writer) error {
+	_, sentinel, err := loadSentinel(options)
+	if err != nil {
+		return err
+	}
+	listWatchers(sentinel, out)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestListWatchers(t *testing.T) {
+	s := Sentinel{Client: &MockClient{}}
+	s.Add([]string{"sentinel/nginx", "sentinel/upstreams"}, &TemplateExecutor{
+		name:      "nginx",
+		prefix:    "sentinel",
+		context:   []string{"sentinel/upstreams"},
+		Templates: []Template{{Src: "/a.tpl", Dest: "/a.conf"}},
+		Command:   []string{"nginx", "-s", "reload"},
+	})
+	s.Add([]string{"sentinel/upstreams"}, &TemplateExecutor{
+		name:      "haproxy",
+		prefix:    "sentinel",
+		context:   []string{"sentinel/upstreams"},
+		Templates: []Template{{Src: "/b.tpl", Dest: "/a.conf"}},
+	})
+
+	out := &bytes.Buffer{}
+	listWatchers(&s, out)
+	want := `haproxy
+  prefix:   sentinel
+  watch:    sentinel/upstreams
+  context:  sentinel/upstreams
+  template: /b.tpl -> /a.conf
+nginx
+  prefix:   sentinel
+  watch:    sentinel/nginx, sentinel/upstreams
+  context:  sentinel/upstreams
+  template: /a.tpl -> /a.conf
+  command:  [nginx -s reload]
+warning: dest /a.conf is written by haproxy, nginx
+warning: watch key sentinel/upstreams is shared by haproxy, nginx
+`
+	if have := out.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
+
+func TestListWatchersDuplicates(t *testing.T) {
+	s := Sentinel{Client: &MockClient{}}
+	s.Add([]string{"sentinel/nginx", "sentinel/nginx"}, &TemplateExecutor{
+		name:    "nginx",
+		prefix:  "sentinel",
+		context: []string{"sentinel/nginx"},
+		Templates: []Template{
+			{Src: "/a.tpl", Dest: "/a.conf"},
+			{Src: "/b.tpl", Dest: "/a.conf"},
+		},
+	})
+
+	out := &bytes.Buffer{}
+	listWatchers(&s, out)
+	want := `nginx
+  prefix:   sentinel
+  watch:    sentinel/nginx
+  context:  sentinel/nginx
+  template: /a.tpl -> /a.conf
+  template: /b.tpl -> /a.conf
+warning: dest /a.conf is written more than once by nginx
+`
+	if have := out.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The default time to live of a lock.
+const DefaultLockTTL = 30 * time.Second
+
+// Configures the lock a watcher takes before running its command.
+type LockConfig struct {
+	// The key to hold the lock at.
+	Key string
+
+	// How long the lock is held if it is not refreshed.
+	TTL time.Duration
+
+	// Wait for the lock if it is held. Otherwise the command is skipped.
+	Wait bool
+}
+
+// Return the key which records the last run made under the lock.
+func (c *LockConfig) RecordKey() string {
+	return c.Key + ".last"
+}
+
+// Records the last run made under a lock so that other instances holding the
+// lock after it do not repeat it.
+type LockRecord struct {
+	// The highest event index which was handled.
+	Index uint64 `json:"index"`
+
+	// A hash of the context the last run was made with.
+	Context string `json:"context"`
+}
+
+// Return a hash of `context` for comparison with a LockRecord.
+func contextHash(context interface{}) (string, error) {
+	data, err := json.Marshal(context)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// Load the record at `key`. A missing record is empty.
+func LoadLockRecord(client Client, key string) (*LockRecord, error) {
+	record := &LockRecord{}
+	value, exists, err := getValue(client, key)
+	if err != nil || !exists {
+		return record, err
+	}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return nil, fmt.Errorf("lock record %s is invalid: %s", key, err)
+	}
+	return record, nil
+}
+
+// Return true if a run for `event` with the context hashed to `hash` was
+// already made. Changes are compared by index. Runs which were not triggered
+// by a change are compared by context.
+func (r *LockRecord) Handled(event *Event, hash string) bool {
+	if event != nil && event.Index > 0 {
+		return event.Index <= r.Index
+	}
+	return hash == r.Context
+}
+
+// Return the record of a run for `event` with the context hashed to `hash`.
+func (r *LockRecord) Next(event *Event, hash string) *LockRecord {
+	next := &LockRecord{Index: r.Index, Context: hash}
+	if event != nil && event.Index > next.Index {
+		next.Index = event.Index
+	}
+	return next
+}
+
+// Save the record to `key`.
+func (r *LockRecord) Save(client Client, key string) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return client.Set(key, string(data), 0)
+}
+
+// Return a value which identifies this process as the holder of a lock.
+func lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
+
+// A lock held through a client. The lock is a key with a time to live which is
+// refreshed while the lock is held.
+type Lock struct {
+	client Client
+	key    string
+	owner  string
+	ttl    time.Duration
+	lock   sync.Mutex
+	stop   chan bool
+	lost   chan bool
+}
+
+// Create a lock on `key` which expires after `ttl` unless refreshed.
+func NewLock(client Client, key string, ttl time.Duration) *Lock {
+	return &Lock{
+		client: client,
+		key:    key,
+		owner:  lockOwner(),
+		ttl:    ttl,
+	}
+}
+
+// Try to acquire the lock. Return true if it was acquired or false if it is
+// held by someone else.
+func (l *Lock) TryAcquire() (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.stop != nil {
+		return true, nil
+	}
+	ok, err := l.client.Create(l.key, l.owner, l.ttl)
+	if !ok || err != nil {
+		return false, err
+	}
+	l.stop = make(chan bool)
+	l.lost = make(chan bool)
+	go l.refresh(l.stop, l.lost)
+	return true, nil
+}
+
+// Acquire the lock, waiting for it to be released if it is held. Return false
+// if the wait was canceled by `stop`.
+func (l *Lock) Acquire(stop <-chan bool) (bool, error) {
+	interval := l.ttl / 3
+	for {
+		if ok, err := l.TryAcquire(); ok || err != nil {
+			return ok, err
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return false, nil
+		}
+	}
+}
+
+// Return a channel which is closed if the lock is lost while held.
+func (l *Lock) Lost() <-chan bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lost
+}
+
+// Keep the lock from expiring until `stop` is closed. Close `lost` if the lock
+// could not be refreshed.
+func (l *Lock) refresh(stop, lost chan bool) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if ok, err := l.client.CompareAndSwap(l.key, l.owner, l.owner, l.ttl); !ok || err != nil {
+				if err != nil {
+					logger.Errorf("lock %s refresh failed: %s", l.key, err)
+				} else {
+					logger.Errorf("lock %s was lost", l.key)
+				}
+				close(lost)
+				return
+			}
+		}
+	}
+}
+
+// Release the lock if it is held.
+func (l *Lock) Release() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.stop == nil {
+		return nil
+	}
+	close(l.stop)
+	l.stop = nil
+	_, err := l.client.CompareAndDelete(l.key, l.owner)
+	return err
+}
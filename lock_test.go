@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	client := &MockClient{}
+	ttl := 60 * time.Millisecond
+	lock1 := NewLock(client, "locks/test", ttl)
+	lock2 := NewLock(client, "locks/test", ttl)
+
+	if ok, err := lock1.TryAcquire(); !ok || err != nil {
+		t.Fatalf("lock1 not acquired: %v", err)
+	}
+	if ok, err := lock2.TryAcquire(); ok || err != nil {
+		t.Fatalf("lock2 acquired while held: %v", err)
+	}
+
+	// the lock is refreshed past its ttl
+	time.Sleep(2 * ttl)
+	if ok, _ := lock2.TryAcquire(); ok {
+		t.Fatal("lock2 acquired after ttl")
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan bool)
+	if ok, err := lock2.Acquire(stop); !ok || err != nil {
+		t.Fatalf("lock2 not acquired after release: %v", err)
+	}
+
+	// the lock is lost if its value changes
+	client.lock.Lock()
+	client.Values["locks/test"].Value = "other"
+	client.lock.Unlock()
+	select {
+	case <-lock2.Lost():
+	case <-time.After(2 * ttl):
+		t.Error("lock loss not detected")
+	}
+	lock2.Release()
+}
+
+func TestExecutorLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := path.Join(dir, "out")
+
+	client := &MockClient{}
+	ex := &TemplateExecutor{
+		name:    "test",
+		Command: []string{"bash", "-c", "echo ran >> " + out},
+		Lock:    &LockConfig{Key: "locks/test", TTL: time.Second},
+	}
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Values["locks/test"]; ok {
+		t.Error("lock not released")
+	}
+
+	other := NewLock(client, "locks/test", time.Second)
+	if ok, _ := other.TryAcquire(); !ok {
+		t.Fatal("lock not acquired")
+	}
+	defer other.Release()
+	if err := ex.Execute(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := ex.Status(); status.Skipped == "" || status.Command != nil {
+		t.Errorf("command not skipped: %+v", status)
+	}
+	if have, _ := ioutil.ReadFile(out); string(have) != "ran\n" {
+		t.Errorf("command ran %q", have)
+	}
+}
+
+func TestExecutorLockHandled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := path.Join(dir, "out")
+
+	// two instances of the same watcher share a lock
+	client := &MockClient{}
+	newExecutor := func(wait bool) *TemplateExecutor {
+		return &TemplateExecutor{
+			name:    "test",
+			Command: []string{"bash", "-c", "echo ran >> " + out},
+			Lock:    &LockConfig{Key: "locks/test", TTL: time.Second, Wait: wait},
+		}
+	}
+	ex1, ex2 := newExecutor(true), newExecutor(true)
+
+	runs := []struct {
+		ex    *TemplateExecutor
+		event *Event
+		want  string
+	}{
+		{ex1, &Event{Key: "test", Index: 5}, "ran\n"},
+		{ex2, &Event{Key: "test", Index: 5}, "ran\n"},
+		{ex2, &Event{Key: "test", Index: 7}, "ran\nran\n"},
+		{ex1, &Event{Key: "test", Index: 6}, "ran\nran\n"},
+		{ex1, nil, "ran\nran\n"},
+	}
+	for i, run := range runs {
+		if err := run.ex.Execute(client, run.event); err != nil {
+			t.Fatal(err)
+		}
+		if have, _ := ioutil.ReadFile(out); string(have) != run.want {
+			t.Errorf("run %d: command ran %q", i, have)
+		}
+	}
+	if status := ex1.Status(); status.Skipped == "" || status.Command != nil {
+		t.Errorf("command not skipped: %+v", status)
+	}
+
+	// a wait for the lock is canceled by stop
+	other := NewLock(client, "locks/test", time.Second)
+	if ok, _ := other.TryAcquire(); !ok {
+		t.Fatal("lock not acquired")
+	}
+	defer other.Release()
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- ex2.ExecuteStop(client, &Event{Key: "test", Index: 8}, stop)
+	}()
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lock wait not canceled")
+	}
+	if status := ex2.Status(); status.Skipped == "" {
+		t.Errorf("command not skipped: %+v", status)
+	}
+}
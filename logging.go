@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/BlueDragonX/go-log.v1"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Log levels in order of increasing severity.
+var logLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"error": 2,
+}
+
+// Structured fields attached to a log record.
+type Fields map[string]interface{}
+
+// A logger which writes either text records through go-log or JSON records
+// with structured fields.
+type Logger struct {
+	lock     sync.Mutex
+	text     *log.Logger
+	json     io.Writer
+	level    int
+	redactor *Redactor
+}
+
+// Create a logger which writes text records to stderr and redacts the default
+// secret keys.
+func NewLogger() *Logger {
+	redactor, _ := NewRedactor(DefaultRedactKeys, nil)
+	return &Logger{text: log.NewOrExit(), level: logLevels["info"], redactor: redactor}
+}
+
+// Set the text log target.
+func (l *Logger) SetTarget(target log.Target) {
+	l.text.SetTarget(target)
+}
+
+// Set the log level by name.
+func (l *Logger) SetLevel(name string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if level, ok := logLevels[strings.ToLower(name)]; ok {
+		l.level = level
+	}
+	l.text.SetLevel(log.NewLevel(name))
+}
+
+// Write JSON records to `w`. Records are written as text if `w` is nil. The
+// previous writer is closed if it is a file other than stdout or stderr.
+func (l *Logger) SetJSON(w io.Writer) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if file, ok := l.json.(*os.File); ok && file != w && file != os.Stdout && file != os.Stderr {
+		file.Close()
+	}
+	l.json = w
+}
+
+// Set the redactor applied to logged values.
+func (l *Logger) SetRedactor(redactor *Redactor) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.redactor = redactor
+}
+
+// Return a copy of `value` with secrets masked. Use this before logging
+// context values.
+func (l *Logger) Redact(value interface{}) interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.redactor.Value(value)
+}
+
+// Return true if records are written as JSON.
+func (l *Logger) IsJSON() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.json != nil
+}
+
+// Return a log entry which attaches `fields` to its records.
+func (l *Logger) With(fields Fields) *LogEntry {
+	return &LogEntry{logger: l, fields: fields}
+}
+
+// Write a record at `level`.
+func (l *Logger) log(level string, fields Fields, msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	msg = l.redactor.String(msg)
+	if l.json == nil {
+		if watcher, ok := fields["watcher"]; ok {
+			msg = fmt.Sprintf("%s: %s", watcher, msg)
+		}
+		switch level {
+		case "debug":
+			l.text.Debug(msg)
+		case "info":
+			l.text.Info(msg)
+		default:
+			l.text.Error(msg)
+		}
+		return
+	}
+
+	if logLevels[level] < l.level {
+		return
+	}
+	record := make(map[string]interface{}, len(fields)+3)
+	for name, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		record[name] = l.redactor.Value(value)
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = level
+	record["msg"] = msg
+	if data, err := json.Marshal(record); err == nil {
+		l.json.Write(append(data, '\n'))
+	}
+}
+
+func (l *Logger) Debug(a ...interface{}) { l.log("debug", nil, fmt.Sprint(a...)) }
+func (l *Logger) Info(a ...interface{})  { l.log("info", nil, fmt.Sprint(a...)) }
+func (l *Logger) Error(a ...interface{}) { l.log("error", nil, fmt.Sprint(a...)) }
+
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	l.log("debug", nil, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) {
+	l.log("info", nil, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) {
+	l.log("error", nil, fmt.Sprintf(format, a...))
+}
+
+// Log an error and exit.
+func (l *Logger) Fatal(a ...interface{}) {
+	l.log("error", nil, fmt.Sprint(a...))
+	os.Exit(1)
+}
+
+// Log a formatted error and exit.
+func (l *Logger) Fatalf(format string, a ...interface{}) {
+	l.log("error", nil, fmt.Sprintf(format, a...))
+	os.Exit(1)
+}
+
+// Return a writer which logs each line written to it at `level`.
+func (l *Logger) Writer(level string) io.Writer {
+	return &logWriter{logger: l, level: level}
+}
+
+// A set of fields to attach to log records.
+type LogEntry struct {
+	logger *Logger
+	fields Fields
+}
+
+// Return an entry with `fields` added to those of this entry.
+func (e *LogEntry) With(fields Fields) *LogEntry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for name, value := range e.fields {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	return &LogEntry{logger: e.logger, fields: merged}
+}
+
+func (e *LogEntry) Debugf(format string, a ...interface{}) {
+	e.logger.log("debug", e.fields, fmt.Sprintf(format, a...))
+}
+
+func (e *LogEntry) Infof(format string, a ...interface{}) {
+	e.logger.log("info", e.fields, fmt.Sprintf(format, a...))
+}
+
+func (e *LogEntry) Errorf(format string, a ...interface{}) {
+	e.logger.log("error", e.fields, fmt.Sprintf(format, a...))
+}
+
+// Logs each line written to it.
+type logWriter struct {
+	logger *Logger
+	level  string
+}
+
+func (w *logWriter) Write(data []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		w.logger.log(w.level, nil, string(line))
+	}
+	return len(data), nil
+}
+
+// Open the writer for JSON records logged to `target`. The target is stdout,
+// stderr, or the path to a file.
+func openLogTarget(target string) (io.Writer, error) {
+	switch target {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	}
+	path := strings.TrimPrefix(target, "file://")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("log target '%s' is not supported by the json format", target)
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger()
+	l.SetJSON(out)
+	l.SetLevel("info")
+
+	l.Debugf("hidden %d", 1)
+	l.With(Fields{"watcher": "nginx", "exit_code": 3}).Errorf("command %s failed", "reload")
+	l.Info("started")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected records: %q", lines)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record["time"]; !ok {
+		t.Error("record has no time")
+	}
+	delete(record, "time")
+	want := map[string]interface{}{
+		"level":     "error",
+		"msg":       "command reload failed",
+		"watcher":   "nginx",
+		"exit_code": float64(3),
+	}
+	if !reflect.DeepEqual(want, record) {
+		t.Errorf("%v != %v", want, record)
+	}
+
+	record = nil
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "info" || record["msg"] != "started" {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger()
+	l.SetJSON(out)
+	l.SetLevel("debug")
+
+	entry := l.With(Fields{"watcher": "nginx", "dest": "a"})
+	entry.With(Fields{"dest": "b"}).Debugf("rendered")
+	entry.Debugf("again")
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 || records[0]["dest"] != "b" || records[1]["dest"] != "a" {
+		t.Errorf("unexpected records: %v", records)
+	}
+}
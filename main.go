@@ -1,19 +1,56 @@
 package main
 
 import (
+	"fmt"
 	"gopkg.in/BlueDragonX/go-log.v1"
 	"gopkg.in/BlueDragonX/go-settings.v1"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 )
 
-var logger *log.Logger = log.NewOrExit()
+var logger *Logger = NewLogger()
 
-// Do basic configuration and return the config object.
-func configure() *settings.Settings {
-	options := ParseOptionsOrExit(os.Args)
-	config := settings.LoadOrExit(options.Config)
+// Return the glob patterns of the drop-in files to load.
+func includePatterns(config *settings.Settings, options *Options) []string {
+	includes := config.StringArrayDflt("include", []string{})
+	if include, err := config.String("include"); err == nil {
+		includes = []string{include}
+	}
+	if include, ok := os.LookupEnv(ConfigEnvName("include")); ok {
+		includes = strings.Split(include, ",")
+	}
+	if options.ConfigDir != "" {
+		includes = append(includes, filepath.Join(options.ConfigDir, "*.yml"))
+	}
+	return includes
+}
+
+// Load the config file, apply the cli `options` to it, and normalize it.
+// Return an error if the file could not be loaded.
+func configure(options *Options) (*settings.Settings, error) {
+	config, err := settings.Load(options.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// expand environment variables so that include globs may reference them
+	if err := ConfigExpandEnv(config, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// merge watchers from drop-in files
+	includes := includePatterns(config, options)
+	if err := ConfigIncludes(config, options.Config, filepath.Dir(options.Config), includes, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// apply SENTINEL_* overrides
+	if err := ConfigEnv(config, os.LookupEnv); err != nil {
+		return nil, err
+	}
 
 	// set config values from cli options
 	config.Set("exec", options.Exec)
@@ -26,17 +63,16 @@ func configure() *settings.Settings {
 	if options.LogLevel != "" {
 		config.Set("logging.level", options.LogLevel)
 	}
-
-	// configure the logger
-	if logTarget, err := config.String("logging.target"); err == nil {
-		if logTargetObj, err := log.NewTarget(logTarget); err == nil {
-			logger.SetTarget(logTargetObj)
-		} else {
-			Fatalf("%s\n", err)
-		}
+	if options.LogFormat != "" {
+		config.Set("logging.format", options.LogFormat)
 	}
-	if logLevel, err := config.String("logging.level"); err == nil {
-		logger.SetLevel(log.NewLevel(logLevel))
+	if options.Prefix != "" {
+		config.Set("etcd.prefix", options.Prefix)
+	}
+	for _, override := range options.Set {
+		if err := ConfigOverride(config, override); err != nil {
+			return nil, err
+		}
 	}
 
 	// normalize etcd configuration
@@ -59,29 +95,157 @@ func configure() *settings.Settings {
 		}
 	}
 
-	return config
+	return config, nil
+}
+
+// Configure the logger from the `config`.
+func configureLogger(config *settings.Settings) error {
+	logTarget := config.StringDflt("logging.target", "")
+	switch format := config.StringDflt("logging.format", LogFormatText); format {
+	case LogFormatText:
+		if logTarget != "" {
+			if logTargetObj, err := log.NewTarget(logTarget); err == nil {
+				logger.SetTarget(logTargetObj)
+			} else {
+				return err
+			}
+		}
+		logger.SetJSON(nil)
+	case LogFormatJSON:
+		if w, err := openLogTarget(logTarget); err == nil {
+			logger.SetJSON(w)
+		} else {
+			return err
+		}
+	default:
+		return fmt.Errorf("log format '%s' is invalid", format)
+	}
+	if logLevel, err := config.String("logging.level"); err == nil {
+		logger.SetLevel(logLevel)
+	}
+	if redactor, err := ConfigRedactor(config.ObjectDflt("logging.redact", &settings.Settings{})); err == nil {
+		logger.SetRedactor(redactor)
+	} else {
+		return err
+	}
+	return nil
+}
+
+// Open the audit log configured in `config`.
+func configureAudit(config *settings.Settings) error {
+	audit, err := ConfigAuditLog(config.ObjectDflt("audit", &settings.Settings{}))
+	if err != nil {
+		return err
+	}
+	setAuditLog(audit)
+	return nil
+}
+
+// Reload the configuration and apply it to the running `sentinel`. The running
+// configuration is kept if the new one is invalid.
+func reload(sentinel *Sentinel, options *Options) {
+	logger.Info("reloading configuration")
+	config, err := configure(options)
+	if err != nil {
+		logger.Errorf("reload failed, keeping current config: %s", err)
+		return
+	}
+	next, err := ConfigSentinel(config)
+	if err != nil {
+		logger.Errorf("reload failed, keeping current config: %s", err)
+		return
+	}
+	if err := sentinel.Reload(next); err != nil {
+		logger.Errorf("reload failed, keeping current config: %s", err)
+		return
+	}
+	if err := configureLogger(config); err != nil {
+		logger.Errorf("reload failed, keeping current logging config: %s", err)
+	}
+	if err := configureAudit(config); err != nil {
+		logger.Errorf("reload failed, keeping current audit config: %s", err)
+	}
+	logger.Info("reload complete")
 }
 
 // Run the app.
 func main() {
-	config := configure()
+	options := ParseOptionsOrExit(os.Args)
+	switch options.Command {
+	case "check-config":
+		if !CheckConfig(options, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	case "context":
+		if err := DumpContext(options, os.Stdout); err != nil {
+			Fatalf("%s\n", err)
+		}
+		return
+	case "list":
+		if err := ListWatchers(options, os.Stdout); err != nil {
+			Fatalf("%s\n", err)
+		}
+		return
+	case "render":
+		if err := RenderTemplate(options, os.Stdout); err != nil {
+			Fatalf("%s\n", err)
+		}
+		return
+	}
+
+	config, err := configure(options)
+	if err != nil {
+		Fatalf("%s\n", err)
+	}
+	if err := configureLogger(config); err != nil {
+		Fatalf("%s\n", err)
+	}
+	if !options.DryRun {
+		if err := configureAudit(config); err != nil {
+			Fatalf("%s\n", err)
+		}
+	}
 	logger.Info("starting sentinel")
 
-	sentinel := ConfigSentinel(config)
+	sentinel, err := ConfigSentinel(config)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	stop := make(chan bool)
-	defer close(stop)
 
 	go func() {
 		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
-		sig := <-signals
-		stop <- true
-		logger.Infof("got signal %s, stopping", sig)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				reload(sentinel, options)
+				continue
+			}
+			close(stop)
+			logger.Infof("got signal %s, stopping", sig)
+			return
+		}
 	}()
 
-	if sentinel.Client.Wait(stop) {
-		exec := config.StringArrayDflt("exec", []string{})
-		if !sentinel.Execute(exec) {
+	exec := config.StringArrayDflt("exec", []string{})
+	if options.DryRun {
+		if !sentinel.Wait(stop) || !sentinel.DryRun(exec, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(exec) == 0 {
+		listener, err := ServeHTTP(config.ObjectDflt("http", &settings.Settings{}), sentinel)
+		if err != nil {
+			logger.Fatalf("failed to serve http: %s", err)
+		} else if listener != nil {
+			defer listener.Close()
+		}
+	}
+
+	if sentinel.Wait(stop) {
+		if (len(exec) > 0 || sentinel.OnStart) && !sentinel.Execute(exec, stop) {
 			os.Exit(1)
 		}
 		if len(exec) > 0 {
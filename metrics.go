@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The default histogram buckets in seconds.
+var DefaultBuckets []float64 = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A metric which can be written in the Prometheus text format.
+type Metric interface {
+	// Write the metric to `w` in the Prometheus text format.
+	Write(w io.Writer) error
+}
+
+// A collection of metrics.
+type Registry struct {
+	lock    sync.Mutex
+	metrics []Metric
+}
+
+// Add a metric to the registry.
+func (r *Registry) Register(metric Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, metric)
+}
+
+// Write all metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, metric := range r.metrics {
+		if err := metric.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format label names and values as a Prometheus label set.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for n, name := range names {
+		value := ""
+		if n < len(values) {
+			value = values[n]
+		}
+		value = strings.Replace(value, `\`, `\\`, -1)
+		value = strings.Replace(value, `"`, `\"`, -1)
+		value = strings.Replace(value, "\n", `\n`, -1)
+		pairs[n] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Format a sample value.
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(value)
+}
+
+// Return the keys of a map of samples in sorted order.
+func sortedSampleKeys(samples map[string]float64) []string {
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write the HELP and TYPE lines of a metric.
+func writeHeader(w io.Writer, name, help, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	return err
+}
+
+// A counter partitioned by labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// Create a counter and add it to `registry`.
+func NewCounter(registry *Registry, name, help string, labels ...string) *Counter {
+	counter := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registry.Register(counter)
+	return counter
+}
+
+// Increment the counter for the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add `delta` to the counter for the given label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[formatLabels(c.labels, values)] += delta
+}
+
+// Return the value of the counter for the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[formatLabels(c.labels, values)]
+}
+
+func (c *Counter) Write(w io.Writer) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := writeHeader(w, c.name, c.help, "counter"); err != nil {
+		return err
+	}
+	for _, labels := range sortedSampleKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(c.values[labels])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A gauge which reports the seconds elapsed since a time, partitioned by
+// labels.
+type SinceGauge struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	times  map[string]time.Time
+}
+
+// Create a since gauge and add it to `registry`.
+func NewSinceGauge(registry *Registry, name, help string, labels ...string) *SinceGauge {
+	gauge := &SinceGauge{name: name, help: help, labels: labels, times: make(map[string]time.Time)}
+	registry.Register(gauge)
+	return gauge
+}
+
+// Set the time to measure from for the given label values.
+func (g *SinceGauge) Set(t time.Time, values ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.times[formatLabels(g.labels, values)] = t
+}
+
+func (g *SinceGauge) Write(w io.Writer) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if err := writeHeader(w, g.name, g.help, "gauge"); err != nil {
+		return err
+	}
+	samples := make(map[string]float64, len(g.times))
+	for labels, t := range g.times {
+		samples[labels] = time.Since(t).Seconds()
+	}
+	for _, labels := range sortedSampleKeys(samples) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatValue(samples[labels])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The observations of a histogram for one set of label values.
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// A histogram partitioned by labels.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+	values  map[string][]string
+}
+
+// Create a histogram and add it to `registry`. The `buckets` are the upper
+// bounds of each bucket in increasing order.
+func NewHistogram(registry *Registry, name, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+		values:  make(map[string][]string),
+	}
+	registry.Register(histogram)
+	return histogram
+}
+
+// Add an observation for the given label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := formatLabels(h.labels, values)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+		h.values[key] = values
+	}
+	for n, bound := range h.buckets {
+		if value <= bound {
+			series.counts[n]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// Add an observation of the seconds elapsed since `start`.
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err := writeHeader(w, h.name, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		series := h.series[key]
+		values := h.values[key]
+		for n, bound := range h.buckets {
+			labels := formatLabels(bucketLabels, append(append([]string{}, values...), formatValue(bound)))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, series.counts[n]); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(bucketLabels, append(append([]string{}, values...), "+Inf"))
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, series.count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatValue(series.sum)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, series.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The metrics exported by Sentinel.
+var (
+	metrics = &Registry{}
+
+	watchEvents = NewCounter(metrics, "sentinel_watch_events_total",
+		"Number of changes seen on a watched prefix.", "prefix")
+	watchErrors = NewCounter(metrics, "sentinel_watch_errors_total",
+		"Number of failed watch attempts by error type.", "prefix", "type")
+	renders = NewCounter(metrics, "sentinel_renders_total",
+		"Number of template renders by result.", "watcher", "result")
+	commandFailures = NewCounter(metrics, "sentinel_command_failures_total",
+		"Number of failed commands.", "watcher")
+	getDuration = NewHistogram(metrics, "sentinel_get_duration_seconds",
+		"Time taken to retrieve the context of a watcher.", DefaultBuckets, "watcher")
+	commandDuration = NewHistogram(metrics, "sentinel_command_duration_seconds",
+		"Time taken to run the command of a watcher.", DefaultBuckets, "watcher")
+	lastSuccess = NewSinceGauge(metrics, "sentinel_seconds_since_last_success",
+		"Seconds since a watcher last executed successfully.", "watcher")
+)
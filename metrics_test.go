@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCounter(t *testing.T) {
+	registry := &Registry{}
+	counter := NewCounter(registry, "test_total", "A test counter.", "prefix", "type")
+	counter.Inc("a", "timeout")
+	counter.Inc("a", "timeout")
+	counter.Add(3, `b"c`, "connection")
+
+	if value := counter.Value("a", "timeout"); value != 2 {
+		t.Errorf("counter value is %v", value)
+	}
+
+	want := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{prefix="a",type="timeout"} 2
+test_total{prefix="b\"c",type="connection"} 3
+`
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if have := buf.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	registry := &Registry{}
+	histogram := NewHistogram(registry, "test_seconds", "A test histogram.", []float64{0.1, 1}, "watcher")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+
+	want := `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{watcher="a",le="0.1"} 1
+test_seconds_bucket{watcher="a",le="1"} 2
+test_seconds_bucket{watcher="a",le="+Inf"} 3
+test_seconds_sum{watcher="a"} 5.55
+test_seconds_count{watcher="a"} 3
+`
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if have := buf.String(); have != want {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
+
+func TestMetricsSinceGauge(t *testing.T) {
+	registry := &Registry{}
+	gauge := NewSinceGauge(registry, "test_seconds_since", "A test gauge.", "watcher")
+	gauge.Set(time.Now().Add(-time.Minute), "a")
+
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if have := buf.String(); !strings.Contains(have, `test_seconds_since{watcher="a"} 60`) {
+		t.Errorf("unexpected output:\n%s", have)
+	}
+}
+
+func TestMetricsExecutor(t *testing.T) {
+	tc := NewExecutorTestCase(t)
+	defer tc.Close()
+
+	exec := TemplateExecutor{
+		name:    "metrics_test",
+		context: []string{"sentinel"},
+		Command: []string{"false"},
+	}
+	before := commandFailures.Value("metrics_test")
+	exec.Execute(tc.Client, nil)
+	if value := commandFailures.Value("metrics_test") - before; value != 1 {
+		t.Errorf("command failures increased by %v", value)
+	}
+}
@@ -2,10 +2,21 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"strings"
+	"time"
 )
 
 var DefaultConfigFile string = "/etc/sentinel.yml"
+var DefaultConfigDir string = "/etc/sentinel.d"
+
+// How long the render and context commands wait for etcd by default.
+var DefaultWaitTimeout time.Duration = 10 * time.Second
+
+// Commands which may be given as the first cli argument. Sentinel runs as a
+// daemon when no command is given.
+var Commands []string = []string{"check-config", "render", "context", "list"}
 
 // A string array option capable of being appended to.
 type stringsOpt []string
@@ -19,39 +30,180 @@ func (strs *stringsOpt) Set(value string) error {
 	return nil
 }
 
+// A key=value option which may be provided multiple times.
+type settingsOpt []string
+
+func (opts *settingsOpt) String() string {
+	return strings.Join(*opts, " ")
+}
+
+func (opts *settingsOpt) Set(value string) error {
+	if !strings.Contains(value, "=") || strings.HasPrefix(value, "=") {
+		return fmt.Errorf("'%s' is not of the form key=value", value)
+	}
+	*opts = append(*opts, value)
+	return nil
+}
+
+// Return the value of the environment variable `name` or `dflt` if it is not
+// set.
+func envDflt(name, dflt string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return dflt
+}
+
+// Return true if `name` is a valid command.
+func isCommand(name string) bool {
+	for _, command := range Commands {
+		if name == command {
+			return true
+		}
+	}
+	return false
+}
+
 // Store values retrieved from the cli.
 type Options struct {
+	Command   string
 	Config    string
+	ConfigDir string
 	Exec      []string
 	Etcd      []string
 	Prefix    string
 	LogTarget string
 	LogLevel  string
+	LogFormat string
+	Set       []string
+	DryRun    bool
+	Args      []string
+
+	// How long the render and context commands wait for etcd. Zero waits
+	// until interrupted.
+	Timeout time.Duration
+
+	// Options of the render command.
+	Watcher     string
+	Template    int
+	Src         string
+	Context     []string
+	ContextFile string
+
+	// Options of the context command.
+	Format string
+	Raw    bool
+}
+
+// Return an error if `command` does not accept the positional `args`.
+func checkArgs(command string, args []string) error {
+	switch {
+	case command == "context" && len(args) != 1:
+		return fmt.Errorf("%s requires a single watcher name", command)
+	case command != "context" && len(args) > 0:
+		return fmt.Errorf("%s does not accept arguments: %s", command, strings.Join(args, " "))
+	}
+	return nil
 }
 
 // Parse cli options. Exit on failure.
 func ParseOptionsOrExit(args []string) *Options {
+	var command string
 	var config string
+	var configDir string
 	var exec stringsOpt
 	var etcd stringsOpt
 	var prefix string
 	var logTarget string
 	var logLevel string
+	var logFormat string
+	var set settingsOpt
+	var dryRun bool
+	var watcher string
+	var template int
+	var src string
+	var context stringsOpt
+	var contextFile string
+	var format string
+	var raw bool
+	var timeout time.Duration
+
+	name := args[0]
+	args = args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+		if !isCommand(command) {
+			fmt.Fprintf(os.Stderr, "unknown command '%s', must be one of: %s\n", command, strings.Join(Commands, ", "))
+			os.Exit(2)
+		}
+		name = fmt.Sprintf("%s %s", name, command)
+	}
 
-	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
-	flags.StringVar(&config, "config", DefaultConfigFile, "YAML configuration file.")
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&config, "config", envDflt("SENTINEL_CONFIG", DefaultConfigFile), "YAML configuration file.")
+	flags.StringVar(&configDir, "config-dir", envDflt("SENTINEL_CONFIG_DIR", DefaultConfigDir), "Directory of YAML files containing additional watchers.")
 	flags.Var(&exec, "exec", "Execute a watcher and exit. May be provided multiple times.")
 	flags.Var(&etcd, "etcd", "The URI of etcd. May be provided multiple times.")
 	flags.StringVar(&prefix, "prefix", "", "A prefix to prepend to all key paths.")
 	flags.StringVar(&logTarget, "log-target", "", "The target to log to.")
 	flags.StringVar(&logLevel, "log-level", "", "The level of logs to log.")
-	flags.Parse(args[1:])
+	flags.StringVar(&logFormat, "log-format", "", "The format of logs, either text or json.")
+	flags.Var(&set, "set", "Set a config value as key=value. The key is a dotted path. May be provided multiple times.")
+	flags.BoolVar(&dryRun, "dry-run", false, "Show the changes the watchers would make and exit.")
+	if command == "render" {
+		flags.StringVar(&watcher, "watcher", "", "The watcher whose template to render.")
+		flags.IntVar(&template, "template", 0, "The index of the watcher's template to render.")
+		flags.StringVar(&src, "src", "", "The path of the template to render.")
+		flags.Var(&context, "context", "A context key to retrieve. May be provided multiple times.")
+		flags.StringVar(&contextFile, "context-file", "", "A JSON or YAML file to use as the context.")
+	}
+	if command == "context" {
+		flags.StringVar(&format, "format", "json", "The output format, either json or yaml.")
+		flags.BoolVar(&raw, "raw", false, "Also show the raw key path of each value.")
+	}
+	if command == "render" || command == "context" {
+		flags.DurationVar(&timeout, "timeout", DefaultWaitTimeout, "How long to wait for etcd. Zero waits until interrupted.")
+	}
+	flags.Parse(args)
+
+	positional := flags.Args()
+	if command != "" {
+		// flags may follow the positional arguments of a command
+		positional = []string{}
+		for flags.NArg() > 0 {
+			positional = append(positional, flags.Arg(0))
+			flags.Parse(flags.Args()[1:])
+		}
+		if err := checkArgs(command, positional); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			flags.Usage()
+			os.Exit(2)
+		}
+	}
 
 	return &Options{
+		Command:   command,
 		Config:    config,
+		ConfigDir: configDir,
 		Exec:      []string(exec),
 		Etcd:      []string(etcd),
+		Prefix:    prefix,
 		LogTarget: logTarget,
 		LogLevel:  logLevel,
+		LogFormat: logFormat,
+		Set:       []string(set),
+		DryRun:    dryRun,
+		Args:      positional,
+		Timeout:   timeout,
+
+		Watcher:     watcher,
+		Template:    template,
+		Src:         src,
+		Context:     []string(context),
+		ContextFile: contextFile,
+
+		Format: format,
+		Raw:    raw,
 	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	options := ParseOptionsOrExit([]string{"sentinel", "context", "nginx", "-format", "yaml", "-timeout", "1s"})
+	if want := []string{"nginx"}; !reflect.DeepEqual(want, options.Args) {
+		t.Errorf("%v != %v", want, options.Args)
+	}
+	if options.Format != "yaml" {
+		t.Errorf("format is '%s'", options.Format)
+	}
+	if options.Timeout != time.Second {
+		t.Errorf("timeout is %s", options.Timeout)
+	}
+
+	options = ParseOptionsOrExit([]string{"sentinel", "render", "-src", "a.tpl"})
+	if options.Src != "a.tpl" || len(options.Args) != 0 {
+		t.Errorf("render options are %+v", options)
+	}
+	if options.Timeout != DefaultWaitTimeout {
+		t.Errorf("timeout is %s", options.Timeout)
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		ok      bool
+	}{
+		{"context", []string{"nginx"}, true},
+		{"context", []string{}, false},
+		{"context", []string{"nginx", "haproxy"}, false},
+		{"render", []string{}, true},
+		{"render", []string{"nginx"}, false},
+		{"list", []string{"nginx"}, false},
+	}
+	for _, test := range tests {
+		if err := checkArgs(test.command, test.args); (err == nil) != test.ok {
+			t.Errorf("%s %v: %v", test.command, test.args, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Replaces redacted values in logs.
+const RedactedValue = "[REDACTED]"
+
+// Key globs which are redacted by default.
+var DefaultRedactKeys []string = []string{"*password*", "*secret*", "*token*"}
+
+// Masks secret values before they are logged. Values are redacted when their
+// key matches one of the `keys` globs or when they match one of the `values`
+// patterns. Keys are matched case insensitively.
+type Redactor struct {
+	keys   []string
+	values []*regexp.Regexp
+}
+
+// Create a redactor from key globs and value patterns.
+func NewRedactor(keys, values []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("redact key '%s' is invalid: %s", key, err)
+		}
+		r.keys = append(r.keys, key)
+	}
+	for _, value := range values {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("redact value '%s' is invalid: %s", value, err)
+		}
+		r.values = append(r.values, re)
+	}
+	return r, nil
+}
+
+// Create a redactor from the `logging.redact` section of `config`. The default
+// keys are included unless `defaults` is false.
+func ConfigRedactor(config *settings.Settings) (*Redactor, error) {
+	keys := []string{}
+	if config.BoolDflt("defaults", true) {
+		keys = append(keys, DefaultRedactKeys...)
+	}
+	keys = append(keys, config.StringArrayDflt("keys", []string{})...)
+	values := config.StringArrayDflt("values", []string{})
+	if value, err := config.String("values"); err == nil {
+		values = []string{value}
+	}
+	return NewRedactor(keys, values)
+}
+
+// Return true if values stored under `key` are secret.
+func (r *Redactor) matchKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, glob := range r.keys {
+		if ok, _ := path.Match(glob, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask the parts of `text` which match a value pattern.
+func (r *Redactor) String(text string) string {
+	if r == nil {
+		return text
+	}
+	for _, re := range r.values {
+		text = re.ReplaceAllString(text, RedactedValue)
+	}
+	return text
+}
+
+// Return a copy of `value` with secrets masked. Map values whose keys match a
+// key glob are replaced and strings are masked by the value patterns.
+func (r *Redactor) Value(value interface{}) interface{} {
+	if r == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if r.matchKey(key) {
+				redacted[key] = RedactedValue
+			} else {
+				redacted[key] = r.Value(item)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for n, item := range v {
+			redacted[n] = r.Value(item)
+		}
+		return redacted
+	case string:
+		return r.String(v)
+	}
+	return value
+}
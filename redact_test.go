@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactorValue(t *testing.T) {
+	r, err := NewRedactor(DefaultRedactKeys, []string{"AKIA[0-9A-Z]{4}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := map[string]interface{}{
+		"db": map[string]interface{}{
+			"user":     "admin",
+			"Password": "hunter2",
+			"api_token": map[string]interface{}{
+				"value": "abc",
+			},
+		},
+		"keys": []interface{}{"AKIAABCD", "plain"},
+	}
+	want := map[string]interface{}{
+		"db": map[string]interface{}{
+			"user":      "admin",
+			"Password":  RedactedValue,
+			"api_token": RedactedValue,
+		},
+		"keys": []interface{}{RedactedValue, "plain"},
+	}
+	if have := r.Value(context); !reflect.DeepEqual(want, have) {
+		t.Errorf("%v != %v", want, have)
+	}
+	if context["db"].(map[string]interface{})["Password"] != "hunter2" {
+		t.Error("original context was modified")
+	}
+}
+
+func TestRedactorInvalid(t *testing.T) {
+	if _, err := NewRedactor([]string{"["}, nil); err == nil {
+		t.Error("invalid key glob accepted")
+	}
+	if _, err := NewRedactor(nil, []string{"("}); err == nil {
+		t.Error("invalid value pattern accepted")
+	}
+}
+
+func TestLoggerRedact(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger()
+	l.SetJSON(out)
+	l.SetLevel("debug")
+	r, err := NewRedactor(DefaultRedactKeys, []string{"s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetRedactor(r)
+
+	l.Debugf("context %+v", l.Redact(map[string]interface{}{"password": "hunter2"}))
+	l.With(Fields{"output": "the s3cr3t is out"}).Errorf("command output")
+
+	if text := out.String(); strings.Contains(text, "hunter2") || strings.Contains(text, "s3cr3t") {
+		t.Errorf("secrets were logged: %s", text)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/BlueDragonX/go-settings.v1"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the location in a template error, e.g. `template: name:3:5:`.
+var templateErrorLocation = regexp.MustCompile(`template: [^:]*:(\d+)(?::(\d+))?:`)
+
+// Convert the maps in a decoded YAML value to maps with string keys.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		mapping := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			mapping[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return mapping
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for n, item := range v {
+			items[n] = normalizeYAML(item)
+		}
+		return items
+	}
+	return value
+}
+
+// Load a template context from a JSON or YAML file.
+func LoadContextFile(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var context interface{}
+	if err := yaml.Unmarshal(data, &context); err != nil {
+		return nil, fmt.Errorf("context file %s is invalid: %s", path, err)
+	}
+	if context == nil {
+		context = map[string]interface{}{}
+	}
+	return normalizeYAML(context), nil
+}
+
+// Format a template error along with the line of `src` it occurred on. The
+// column is marked if it is known.
+func FormatTemplateError(src string, err error) string {
+	message := err.Error()
+	match := templateErrorLocation.FindStringSubmatch(message)
+	if match == nil {
+		return message
+	}
+	data, readErr := ioutil.ReadFile(src)
+	if readErr != nil {
+		return message
+	}
+	lineNum, _ := strconv.Atoi(match[1])
+	lines := strings.Split(string(data), "\n")
+	if lineNum < 1 || lineNum > len(lines) {
+		return message
+	}
+
+	out := &bytes.Buffer{}
+	gutter := fmt.Sprintf("%d | ", lineNum)
+	fmt.Fprintf(out, "%s\n%s%s\n", message, gutter, lines[lineNum-1])
+	if match[2] != "" {
+		col, _ := strconv.Atoi(match[2])
+		fmt.Fprintf(out, "%s| %s^\n", strings.Repeat(" ", len(gutter)-2), strings.Repeat(" ", col))
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// Load the config and logger as given by the cli `options` and create the
+// sentinel it describes.
+func loadSentinel(options *Options) (*settings.Settings, *Sentinel, error) {
+	config, err := configure(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := configureLogger(config); err != nil {
+		return nil, nil, err
+	}
+	sentinel, err := ConfigSentinel(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, sentinel, nil
+}
+
+// Return the executor which renders the template selected by the cli
+// `options`. The executor has a single template.
+func renderExecutor(options *Options) (*TemplateExecutor, Client, error) {
+	if options.Watcher == "" && options.Src != "" && options.ContextFile != "" {
+		// no config is needed to render a file against a fixture
+		return &TemplateExecutor{Templates: []Template{{Src: options.Src}}}, nil, nil
+	}
+
+	config, sentinel, err := loadSentinel(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ex := &TemplateExecutor{}
+	if options.Watcher != "" {
+		if ex, err = sentinel.templateExecutor(options.Watcher); err != nil {
+			return nil, nil, err
+		}
+		ex = &TemplateExecutor{name: ex.name, prefix: ex.prefix, context: ex.context, Templates: ex.Templates}
+	} else {
+		ex.prefix = CleanPath(config.StringDflt("etcd.prefix", ""))
+	}
+	if len(options.Context) > 0 {
+		ex.context = ResolvePaths(ex.prefix, append([]string{}, options.Context...))
+	}
+
+	if options.Src != "" {
+		ex.Templates = []Template{{Src: options.Src}}
+	} else if options.Watcher == "" {
+		return nil, nil, fmt.Errorf("one of -watcher or -src is required")
+	} else if options.Template < 0 || options.Template >= len(ex.Templates) {
+		return nil, nil, fmt.Errorf("watcher %s has no template %d", options.Watcher, options.Template)
+	} else {
+		ex.Templates = []Template{ex.Templates[options.Template]}
+	}
+	return ex, sentinel.Client, nil
+}
+
+// Render the template selected by the cli `options` to `out`. The context is
+// loaded from the context file if one is given. Otherwise it is retrieved from
+// the server.
+func RenderTemplate(options *Options, out io.Writer) error {
+	ex, client, err := renderExecutor(options)
+	if err != nil {
+		return err
+	}
+
+	var context interface{}
+	if options.ContextFile != "" {
+		context, err = LoadContextFile(options.ContextFile)
+	} else if err = waitClient(client, options.Timeout); err == nil {
+		context, err = ex.getContext(client)
+	}
+	if err != nil {
+		return err
+	}
+
+	tpl := ex.Templates[0]
+	content, err := tpl.render(context)
+	if err != nil {
+		return fmt.Errorf("%s", FormatTemplateError(tpl.Src, err))
+	}
+	_, err = out.Write(content)
+	return err
+}
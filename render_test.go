@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.tpl":        "a={{ .value }}\n",
+		"b.tpl":        "b={{ .nested.value }}\n",
+		"context.yml":  "value: 1\nnested:\n  value: 2\n",
+		"context.json": `{"value": "x", "nested": {"value": "y"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "sentinel.yml")
+	content := strings.Replace(`
+watchers:
+  test:
+    templates:
+    - src: DIR/a.tpl
+      dest: DIR/a.out
+    - src: DIR/b.tpl
+      dest: DIR/b.out
+`, "DIR", dir, -1)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options *Options
+		want    string
+	}{
+		{&Options{Config: file, Watcher: "test", ContextFile: path.Join(dir, "context.yml")}, "a=1\n"},
+		{&Options{Config: file, Watcher: "test", Template: 1, ContextFile: path.Join(dir, "context.json")}, "b=y\n"},
+		{&Options{Src: path.Join(dir, "b.tpl"), ContextFile: path.Join(dir, "context.yml")}, "b=2\n"},
+	}
+	for _, test := range tests {
+		out := &bytes.Buffer{}
+		if err := RenderTemplate(test.options, out); err != nil {
+			t.Error(err)
+		} else if have := out.String(); have != test.want {
+			t.Errorf("'%s' != '%s'", test.want, have)
+		}
+	}
+
+	options := &Options{Config: file, Watcher: "test", Template: 2, ContextFile: path.Join(dir, "context.yml")}
+	if err := RenderTemplate(options, &bytes.Buffer{}); err == nil {
+		t.Error("missing template rendered")
+	}
+}
+
+func TestFormatTemplateError(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"test.tpl":    "a\nbc {{ .foo.bar }}\n",
+		"context.yml": "foo: 3\n",
+	})
+	defer os.RemoveAll(dir)
+
+	src := path.Join(dir, "test.tpl")
+	options := &Options{Src: src, ContextFile: path.Join(dir, "context.yml")}
+	err := RenderTemplate(options, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("invalid context rendered")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "test.tpl:2:10") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if lines[1] != "2 | bc {{ .foo.bar }}" || lines[2] != "  |           ^" {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+)
+
+// An executor which is also run periodically.
+type IntervalExecutor interface {
+	// Return how often the executor is run. Zero disables periodic runs.
+	Interval() time.Duration
+}
+
+// Return how often `executor` is run periodically or zero if it is not.
+func executorInterval(executor Executor) time.Duration {
+	if interval, ok := executor.(IntervalExecutor); ok {
+		return interval.Interval()
+	}
+	return 0
+}
+
+// A running timer which periodically requests the resync of an executor.
+type resync struct {
+	interval time.Duration
+	stop     chan bool
+}
+
+// Start a timer which sends `name` to the sentinel's resync channel every
+// `interval`.
+func (s *Sentinel) startResync(name string, interval time.Duration) *resync {
+	r := &resync{interval: interval, stop: make(chan bool)}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case s.resync <- name:
+				case <-r.stop:
+					return
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// Start and stop resync timers so that they match the configured executors.
+// Must be called with the lock held.
+func (s *Sentinel) updateResyncs() {
+	for name, r := range s.resyncs {
+		executor, ok := s.executorsByName[name]
+		if !ok || executorInterval(executor) != r.interval {
+			logger.Debugf("stopping resync of %s", name)
+			close(r.stop)
+			delete(s.resyncs, name)
+		}
+	}
+	for name, executor := range s.executorsByName {
+		interval := executorInterval(executor)
+		if _, ok := s.resyncs[name]; !ok && interval > 0 {
+			logger.Debugf("resyncing %s every %s", name, interval)
+			s.resyncs[name] = s.startResync(name, interval)
+		}
+	}
+}
+
+// Stop all resync timers. Must be called with the lock held.
+func (s *Sentinel) stopResyncs() {
+	for _, r := range s.resyncs {
+		close(r.stop)
+	}
+	s.resyncs = nil
+}
+
+// Run the named executor for a resync.
+func (s *Sentinel) executeResync(name string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	executor, ok := s.executorsByName[name]
+	if !ok || !s.canExecute(executor) {
+		return
+	}
+	logger.With(Fields{"watcher": name}).Debugf("resyncing")
+	if err := s.execute(executor, nil, s.stop); err != nil {
+		logger.With(Fields{"watcher": name}).Errorf("execution failed: %s", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type MockIntervalExecutor struct {
+	MockExecutor
+	interval time.Duration
+}
+
+func (ex *MockIntervalExecutor) Interval() time.Duration {
+	return ex.interval
+}
+
+func TestSentinelResync(t *testing.T) {
+	client := &MockClient{}
+	ex := &MockIntervalExecutor{MockExecutor: MockExecutor{name: "mock"}, interval: 10 * time.Millisecond}
+	s := Sentinel{Client: client}
+	s.Add([]string{"1"}, ex)
+	stop := make(chan bool)
+	join := make(chan struct{})
+
+	go func() {
+		s.Run(stop)
+		close(join)
+	}()
+
+	// the executor runs on the interval
+	time.Sleep(55 * time.Millisecond)
+	if calls := ex.Count(); calls < 2 {
+		t.Errorf("executor called %d times, want at least 2", calls)
+	}
+
+	// removing the interval stops the resync
+	other := Sentinel{Client: client}
+	other.Add([]string{"1"}, &MockIntervalExecutor{MockExecutor: MockExecutor{name: "mock"}})
+	if err := s.Reload(&other); err != nil {
+		t.Fatal(err)
+	}
+	calls := ex.Count()
+	time.Sleep(30 * time.Millisecond)
+	if ex.Count() != calls {
+		t.Error("executor resynced after its interval was removed")
+	}
+
+	stop <- true
+	<-join
+}
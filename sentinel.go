@@ -1,9 +1,40 @@
 package main
 
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
 type Sentinel struct {
 	Client          Client
 	executorsByName map[string]Executor
 	executorsByKey  map[string][]Executor
+	changes         chan *Event
+	watches         map[string]*watch
+	lock            sync.RWMutex
+	triggers        map[string]trigger
+	indexes         map[string]uint64
+	statusLock      sync.Mutex
+	health          Health
+	Elector         *Elector
+	elected         chan bool
+	electionStop    chan bool
+	resyncs         map[string]*resync
+	resync          chan string
+	OnStart         bool
+	StatePath       string
+	stop            chan bool
+}
+
+// A running watch on a single prefix.
+type watch struct {
+	stop chan bool
+	done chan struct{}
+	join chan struct{}
 }
 
 // Add an `executor` for the provided `keys`.
@@ -27,13 +58,333 @@ func (s *Sentinel) Add(keys []string, executor Executor) {
 	}
 }
 
-// Look up a executors by key and execute them.
-func (s *Sentinel) executeKey(key string) {
+// Replace the executors with those configured in `other`. Executors which are
+// unchanged are kept. If the sentinel is running then watches are started on
+// new keys and stopped on keys which are no longer used. Watches on keys which
+// remain in use are left running so that no changes are missed. The etcd
+// client and leader election can not be changed; an error is returned and
+// nothing is reloaded if they differ.
+func (s *Sentinel) Reload(other *Sentinel) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !clientsEqual(s.Client, other.Client) {
+		return fmt.Errorf("etcd changes require a restart")
+	}
+	if !s.Elector.Equal(other.Elector) {
+		return fmt.Errorf("leader changes require a restart")
+	}
+
+	executorsByName := make(map[string]Executor, len(other.executorsByName))
+	for name, executor := range other.executorsByName {
+		if current, ok := s.executorsByName[name]; !ok {
+			logger.Infof("watcher %s added", name)
+		} else if executorsEqual(current, executor) {
+			executor = current
+		} else {
+			logger.Infof("watcher %s changed", name)
+		}
+		executorsByName[name] = executor
+	}
+	for name := range s.executorsByName {
+		if _, ok := executorsByName[name]; !ok {
+			logger.Infof("watcher %s removed", name)
+		}
+	}
+
+	executorsByKey := make(map[string][]Executor, len(other.executorsByKey))
+	for key, executors := range other.executorsByKey {
+		executorArray := make([]Executor, len(executors))
+		for n, executor := range executors {
+			executorArray[n] = executorsByName[executor.Name()]
+		}
+		executorsByKey[key] = executorArray
+	}
+
+	s.executorsByName = executorsByName
+	s.executorsByKey = executorsByKey
+	s.StatePath = other.StatePath
+	if s.watches != nil {
+		s.updateWatches()
+		s.updateResyncs()
+		s.updateElection()
+	}
+	return nil
+}
+
+// Return true if two clients are configured the same.
+func clientsEqual(a, b Client) bool {
+	if equaler, ok := a.(interface {
+		Equal(Client) bool
+	}); ok {
+		return equaler.Equal(b)
+	}
+	return a == b
+}
+
+// Return true if two executors are configured the same.
+func executorsEqual(a, b Executor) bool {
+	if equaler, ok := a.(interface {
+		Equal(Executor) bool
+	}); ok {
+		return equaler.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Record the `event` as the last trigger of the executors watching its prefix.
+func (s *Sentinel) recordEvent(event *Event) {
+	s.lock.RLock()
+	executors := s.executorsByKey[event.Prefix]
+	s.lock.RUnlock()
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.triggers == nil {
+		s.triggers = make(map[string]trigger)
+	}
+	now := time.Now()
+	for _, executor := range executors {
+		s.triggers[executor.Name()] = trigger{now, event}
+	}
+}
+
+// Record that the change described by `event` was handled and save the watch
+// indexes to the state file. Watches resume after the recorded index.
+func (s *Sentinel) handledEvent(event *Event) {
+	s.statusLock.Lock()
+	if s.indexes == nil {
+		s.indexes = make(map[string]uint64)
+	}
+	s.indexes[event.Prefix] = event.Index
+	s.statusLock.Unlock()
+	s.saveState()
+}
+
+// Load the watch indexes from the state file. Watches resume after the loaded
+// indexes.
+func (s *Sentinel) loadState() {
+	if s.StatePath == "" {
+		return
+	}
+	state, err := LoadState(s.StatePath)
+	if err != nil {
+		logger.Errorf("failed to load state, watching from the current index: %s", err)
+		return
+	}
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.indexes = state.Indexes
+}
+
+// Save the watch indexes to the state file.
+func (s *Sentinel) saveState() {
+	if s.StatePath == "" {
+		return
+	}
+	s.statusLock.Lock()
+	state := &State{Indexes: make(map[string]uint64, len(s.indexes))}
+	for prefix, index := range s.indexes {
+		state.Indexes[prefix] = index
+	}
+	s.statusLock.Unlock()
+	if err := state.Save(s.StatePath); err != nil {
+		logger.Errorf("failed to save state: %s", err)
+	}
+}
+
+// Return the status of each watcher sorted by name.
+func (s *Sentinel) Status() []WatcherStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	keysByName := make(map[string][]string)
+	for _, key := range s.getPrefixes() {
+		for _, executor := range s.executorsByKey[key] {
+			keysByName[executor.Name()] = append(keysByName[executor.Name()], key)
+		}
+	}
+
+	statuses := make([]WatcherStatus, 0, len(s.executorsByName))
+	for name, executor := range s.executorsByName {
+		status := WatcherStatus{Name: name, Watch: []WatchStatus{}}
+		keys := keysByName[name]
+		sort.Strings(keys)
+		for _, key := range keys {
+			status.Watch = append(status.Watch, WatchStatus{Key: key, Index: s.indexes[key]})
+		}
+		if trigger, ok := s.triggers[name]; ok {
+			triggerTime := trigger.time
+			status.LastTrigger = &triggerTime
+			status.LastKey = trigger.event.Key
+		}
+		if reporter, ok := executor.(StatusReporter); ok {
+			status.LastExecution = reporter.Status()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Sort(watcherStatusByName(statuses))
+	return statuses
+}
+
+// Wait for the client to connect. Return true if it connected or false if the
+// wait was canceled by `stop`.
+func (s *Sentinel) Wait(stop chan bool) bool {
+	if !s.Client.Wait(stop) {
+		return false
+	}
+	s.health.setConnected()
+	return true
+}
+
+// Return an error if the run loop has stopped or is not responding.
+func (s *Sentinel) Alive(timeout time.Duration) error {
+	return s.health.Alive(timeout)
+}
+
+// Return an error if the sentinel is not ready. See Health.Ready. Executors
+// only run on changes when they are not run on start so they are ready once
+// the watches are running.
+func (s *Sentinel) Ready(backoffTimeout time.Duration) error {
+	if !s.OnStart {
+		if err := s.health.Watching(); err != nil {
+			return err
+		}
+	}
+	s.lock.RLock()
+	names := make([]string, 0, len(s.executorsByName))
+	for name, executor := range s.executorsByName {
+		if s.OnStart && s.canExecute(executor) {
+			names = append(names, name)
+		}
+	}
+	prefixes := s.getPrefixes()
+	s.lock.RUnlock()
+	return s.health.Ready(names, prefixes, backoffTimeout)
+}
+
+// Return true if `executor` may run on this instance. Leader only executors
+// may only run on the leader.
+func (s *Sentinel) canExecute(executor Executor) bool {
+	return !isLeaderOnly(executor) || (s.Elector != nil && s.Elector.IsLeader())
+}
+
+// Run an executor and record its success. The `event` is the change which
+// triggered the execution or nil. Waits in the executor are canceled when
+// `stop` is closed.
+func (s *Sentinel) execute(executor Executor, event *Event, stop <-chan bool) error {
+	var err error
+	if stoppable, ok := executor.(StoppableExecutor); ok {
+		err = stoppable.ExecuteStop(s.Client, event, stop)
+	} else {
+		err = executor.Execute(s.Client, event)
+	}
+	if err == nil {
+		s.health.setExecuted(executor.Name())
+	}
+	return err
+}
+
+// Look up a executors by key and execute them. The `event` is the change
+// which triggered the execution or nil. Return true if all executors
+// succeeded.
+func (s *Sentinel) executeKey(key string, event *Event) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	success := true
 	if executors, ok := s.executorsByKey[key]; ok {
 		for _, executor := range executors {
-			executor.Execute(s.Client)
+			if !s.canExecute(executor) {
+				continue
+			}
+			if err := s.execute(executor, event, s.stop); err != nil {
+				logger.With(Fields{"watcher": executor.Name()}).Errorf("execution failed: %s", err)
+				success = false
+			}
 		}
 	}
+	return success
+}
+
+// Execute all leader only executors if this instance is the leader.
+func (s *Sentinel) executeLeaderOnly() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, executor := range s.executorsByName {
+		if isLeaderOnly(executor) && s.canExecute(executor) {
+			if err := s.execute(executor, nil, s.stop); err != nil {
+				logger.With(Fields{"watcher": executor.Name()}).Errorf("execution failed: %s", err)
+			}
+		}
+	}
+}
+
+// Start the election if there are leader only executors or stop it if there
+// are none. Must be called with the lock held.
+func (s *Sentinel) updateElection() {
+	needed := false
+	for _, executor := range s.executorsByName {
+		if isLeaderOnly(executor) {
+			needed = true
+			break
+		}
+	}
+	if needed && s.electionStop == nil && s.Elector != nil {
+		logger.Debug("starting leader election")
+		s.electionStop = make(chan bool)
+		go s.Elector.Run(s.elected, s.electionStop)
+	} else if !needed && s.electionStop != nil {
+		logger.Debug("stopping leader election")
+		close(s.electionStop)
+		s.electionStop = nil
+	}
+}
+
+// Handle an event sent by a watch.
+func (s *Sentinel) handleEvent(event *Event) {
+	eventLog := logger.With(Fields{"prefix": event.Prefix, "key": event.Key, "index": event.Index})
+	switch event.Action {
+	case ActionError:
+		eventLog.Debugf("watch on '%s' is failing", event.Prefix)
+		s.health.setBackoff(event.Prefix)
+	case ActionReconnect:
+		// changes may have been missed during the outage
+		eventLog.Infof("watch on '%s' reconnected, executing all watchers", event.Prefix)
+		s.health.clearBackoff(event.Prefix)
+		s.executeKey(event.Prefix, event)
+	case ActionReset:
+		// changes may have been missed so run everything on the prefix
+		eventLog.Infof("watch on '%s' was reset, executing all watchers", event.Prefix)
+		s.health.clearBackoff(event.Prefix)
+		s.recordEvent(event)
+		if s.executeKey(event.Prefix, event) {
+			s.handledEvent(event)
+		}
+	default:
+		eventLog.Debugf("prefix '%s' changed", event.Prefix)
+		s.health.clearBackoff(event.Prefix)
+		s.recordEvent(event)
+		if s.executeKey(event.Prefix, event) {
+			s.handledEvent(event)
+		}
+	}
+}
+
+// Return the named template executor.
+func (s *Sentinel) templateExecutor(name string) (*TemplateExecutor, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	executor, ok := s.executorsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("watcher %s not found", name)
+	}
+	ex, ok := asTemplateExecutor(executor)
+	if !ok {
+		return nil, fmt.Errorf("watcher %s does not render templates", name)
+	}
+	return ex, nil
 }
 
 // Get the prefixes we're configured to watch.
@@ -45,15 +396,84 @@ func (s *Sentinel) getPrefixes() []string {
 	return prefixes
 }
 
-// Execute the named executors. If `names` is empty all executors will be run.
-// A failed executor will not cause subsequent executors to be skipped.
-// Failures are logged. Return true if all executors succeeded.
-func (s *Sentinel) Execute(names []string) bool {
+// Start watching a single prefix. Changes are forwarded to the sentinel's
+// changes channel until the watch is stopped.
+func (s *Sentinel) startWatch(prefix string) *watch {
+	w := &watch{
+		stop: make(chan bool),
+		done: make(chan struct{}),
+		join: make(chan struct{}),
+	}
+	s.statusLock.Lock()
+	indexes := map[string]uint64{prefix: s.indexes[prefix]}
+	s.statusLock.Unlock()
+
+	changes := make(chan *Event, 10)
+	go func() {
+		s.Client.Watch([]string{prefix}, indexes, changes, w.stop)
+		close(w.join)
+	}()
+	go func() {
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+				select {
+				case s.changes <- change:
+				case <-w.done:
+				}
+			case <-w.join:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Stop a watch and wait for it to exit.
+func (s *Sentinel) stopWatch(w *watch) {
+	close(w.done)
+	w.stop <- true
+	<-w.join
+}
+
+// Start and stop watches so that they match the configured prefixes. Must be
+// called with the lock held.
+func (s *Sentinel) updateWatches() {
+	for prefix, w := range s.watches {
+		if _, ok := s.executorsByKey[prefix]; !ok {
+			logger.Debugf("stopping watch on %s", prefix)
+			s.stopWatch(w)
+			delete(s.watches, prefix)
+		}
+	}
+	for _, prefix := range s.getPrefixes() {
+		if _, ok := s.watches[prefix]; !ok {
+			logger.Debugf("starting watch on %s", prefix)
+			s.watches[prefix] = s.startWatch(prefix)
+		}
+	}
+}
+
+// Execute the named executors. If `names` is empty all executors will be run
+// except for leader only executors when this instance is not the leader. A
+// failed executor will not cause subsequent executors to be skipped. Failures
+// are logged. Waits for locks are canceled by closing `stop`. Return true if
+// all executors succeeded.
+func (s *Sentinel) Execute(names []string, stop chan bool) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	success := true
 	if len(names) == 0 {
 		for _, executor := range s.executorsByName {
-			if err := executor.Execute(s.Client); err != nil {
-				logger.Errorf("executor %s failed: %s", executor.Name(), err)
+			if !s.canExecute(executor) {
+				continue
+			}
+			if err := s.execute(executor, nil, stop); err != nil {
+				logger.With(Fields{"watcher": executor.Name()}).Errorf("execution failed: %s", err)
 				success = false
 			}
 		}
@@ -66,8 +486,8 @@ func (s *Sentinel) Execute(names []string) bool {
 		}
 		for _, name := range names {
 			executor := s.executorsByName[name]
-			if err := executor.Execute(s.Client); err != nil {
-				logger.Errorf("executor %s failed: %s", executor.Name(), err)
+			if err := s.execute(executor, nil, stop); err != nil {
+				logger.With(Fields{"watcher": executor.Name()}).Errorf("execution failed: %s", err)
 				success = false
 			}
 		}
@@ -75,25 +495,96 @@ func (s *Sentinel) Execute(names []string) bool {
 	return success
 }
 
+// Watch for changes and run the associated executors until a value is sent to
+// `stop` or it is closed. Watches resume after the indexes saved in the state
+// file.
 func (s *Sentinel) Run(stop chan bool) {
-	changes := make(chan string, 10)
-	watchStop := make(chan bool)
-	watchJoin := make(chan struct{})
+	s.loadState()
+	// Executors waiting on a lock block the loop so they are canceled through
+	// a channel which is closed as soon as the stop is received.
+	stopping := make(chan bool)
 	go func() {
-		s.Client.Watch(s.getPrefixes(), changes, watchStop)
-		close(watchJoin)
+		<-stop
+		close(stopping)
 	}()
 
+	s.lock.Lock()
+	s.stop = stopping
+	s.changes = make(chan *Event, 10)
+	s.watches = make(map[string]*watch)
+	s.elected = make(chan bool)
+	s.resyncs = make(map[string]*resync)
+	s.resync = make(chan string)
+	s.updateWatches()
+	s.updateResyncs()
+	s.updateElection()
+	s.lock.Unlock()
+
+	s.health.setRunning(true)
+	defer s.health.setRunning(false)
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 Loop:
 	for {
 		select {
-		case <-stop:
-			watchStop <- true
-			<-watchJoin
+		case <-heartbeat.C:
+			s.health.beat()
+		case <-stopping:
+			s.lock.Lock()
+			for _, w := range s.watches {
+				s.stopWatch(w)
+			}
+			s.watches = nil
+			s.stopResyncs()
+			if s.electionStop != nil {
+				close(s.electionStop)
+				s.electionStop = nil
+			}
+			s.lock.Unlock()
 			break Loop
-		case prefix := <-changes:
-			logger.Debugf("prefix '%s' changed", prefix)
-			s.executeKey(prefix)
+		case event := <-s.changes:
+			s.handleEvent(event)
+		case <-s.elected:
+			s.executeLeaderOnly()
+		case name := <-s.resync:
+			s.executeResync(name)
+		}
+	}
+}
+
+// Show the changes the named executors would make without making them. If
+// `names` is empty all executors are shown. The changes are written to `out`.
+// Return true if all executors succeeded.
+func (s *Sentinel) DryRun(names []string, out io.Writer) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(names) == 0 {
+		for name := range s.executorsByName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if _, ok := s.executorsByName[name]; !ok {
+			logger.Errorf("executor %s not found", name)
+			return false
+		}
+	}
+
+	success := true
+	for _, name := range names {
+		fmt.Fprintf(out, "==> %s\n", name)
+		dryRunner, ok := s.executorsByName[name].(DryRunner)
+		if !ok {
+			fmt.Fprintln(out, "dry run not supported")
+			continue
+		}
+		if err := dryRunner.DryRun(s.Client, out); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+			success = false
 		}
 	}
+	return success
 }
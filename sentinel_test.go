@@ -2,14 +2,16 @@ package main
 
 import (
 	"errors"
-	"gopkg.in/BlueDragonX/go-log.v1"
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"testing"
 	"time"
 )
 
 func init() {
-	logger.SetLevel(log.LevelDebug)
+	logger.SetLevel("debug")
 }
 
 func TestSentinelAdd(t *testing.T) {
@@ -57,7 +59,7 @@ func TestSentinelExecute(t *testing.T) {
 	s.Add(keys1, ex1)
 	s.Add(keys2, ex2)
 
-	if !s.Execute([]string{"mock1"}) {
+	if !s.Execute([]string{"mock1"}, nil) {
 		t.Error("an executor failed")
 	}
 	if ex1.Calls != 1 {
@@ -67,7 +69,7 @@ func TestSentinelExecute(t *testing.T) {
 		t.Error("executor was called")
 	}
 
-	if !s.Execute([]string{"mock1", "mock2"}) {
+	if !s.Execute([]string{"mock1", "mock2"}, nil) {
 		t.Error("an executor failed")
 	}
 	if ex1.Calls != 2 {
@@ -78,7 +80,7 @@ func TestSentinelExecute(t *testing.T) {
 	}
 
 	ex1.Error = errors.New("oops!")
-	if s.Execute([]string{"mock1", "mock2"}) {
+	if s.Execute([]string{"mock1", "mock2"}, nil) {
 		t.Error("execute succeeded")
 	}
 	if ex1.Calls != 3 {
@@ -89,7 +91,7 @@ func TestSentinelExecute(t *testing.T) {
 	}
 
 	ex1.Error = nil
-	if !s.Execute([]string{}) {
+	if !s.Execute([]string{}, nil) {
 		t.Error("an executor failed")
 	}
 	if ex1.Calls != 4 {
@@ -99,7 +101,7 @@ func TestSentinelExecute(t *testing.T) {
 		t.Error("executor not called")
 	}
 
-	if s.Execute([]string{"sirnotappearinginthisfilm"}) {
+	if s.Execute([]string{"sirnotappearinginthisfilm"}, nil) {
 		t.Error("execute succeeded")
 	}
 	if ex1.Calls != 4 {
@@ -121,7 +123,7 @@ func TestSentinelExecuteKey(t *testing.T) {
 	s.Add(keys1, ex1)
 	s.Add(keys2, ex2)
 
-	s.executeKey("1")
+	s.executeKey("1", nil)
 	if ex1.Calls != 1 {
 		t.Error("executor1 not called")
 	}
@@ -129,7 +131,7 @@ func TestSentinelExecuteKey(t *testing.T) {
 		t.Error("executor2 called")
 	}
 
-	s.executeKey("2")
+	s.executeKey("2", nil)
 	if ex1.Calls != 2 {
 		t.Error("executor1 not called")
 	}
@@ -160,19 +162,208 @@ func TestSentinelRun(t *testing.T) {
 	time.Sleep(1 * time.Millisecond)
 
 	// change causes execution
-	client.Changes <- "sentinel"
+	client.Send(&Event{Prefix: "sentinel", Key: "sentinel/a", Index: 1})
 	time.Sleep(1 * time.Millisecond)
-	if ex.Calls != 1 {
+	if ex.Count() != 1 {
 		t.Error("executor not called")
 	}
 
 	// change to other key causes no execution
-	client.Changes <- "beacon"
+	client.Send(&Event{Prefix: "beacon", Key: "beacon", Index: 2})
 	time.Sleep(1 * time.Millisecond)
-	if ex.Calls != 1 {
+	if ex.Count() != 1 {
 		t.Error("executor called")
 	}
 
+	// reconnect causes execution
+	client.Send(&Event{Prefix: "sentinel", Key: "sentinel", Action: ActionReconnect, Index: 3})
+	time.Sleep(1 * time.Millisecond)
+	if ex.Count() != 2 {
+		t.Error("executor not called on reconnect")
+	}
+
+	// failure causes no execution
+	client.Send(&Event{Prefix: "sentinel", Key: "sentinel", Action: ActionError, Index: 3})
+	time.Sleep(1 * time.Millisecond)
+	if ex.Count() != 2 {
+		t.Error("executor called on failure")
+	}
+
+	stop <- true
+	<-join
+}
+
+func TestSentinelState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := path.Join(dir, "state.json")
+	if err := (&State{Indexes: map[string]uint64{"sentinel": 5}}).Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &MockClient{}
+	ex := &MockExecutor{name: "mock"}
+	failing := &MockExecutor{name: "failing", Error: errors.New("failed")}
+	s := Sentinel{Client: client, StatePath: statePath}
+	s.Add([]string{"sentinel"}, ex)
+	s.Add([]string{"failing"}, failing)
+	stop := make(chan bool)
+	join := make(chan struct{})
+
+	go func() {
+		s.Run(stop)
+		close(join)
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	// the watch resumes from the saved index
+	if index := client.WatchIndex("sentinel"); index != 5 {
+		t.Errorf("watch started at index %d, want 5", index)
+	}
+
+	// processed changes are saved
+	client.Send(&Event{Prefix: "sentinel", Key: "sentinel/a", Index: 7})
+	time.Sleep(1 * time.Millisecond)
+	if state, err := LoadState(statePath); err != nil {
+		t.Error(err)
+	} else if state.Indexes["sentinel"] != 7 {
+		t.Errorf("saved index is %d, want 7", state.Indexes["sentinel"])
+	}
+
+	// a reset executes the watchers on the prefix
+	client.Send(&Event{Prefix: "sentinel", Key: "sentinel", Action: ActionReset, Index: 20})
+	time.Sleep(1 * time.Millisecond)
+	if ex.Count() != 2 {
+		t.Errorf("executor called %d times, want 2", ex.Count())
+	}
+	if state, err := LoadState(statePath); err != nil {
+		t.Error(err)
+	} else if state.Indexes["sentinel"] != 20 {
+		t.Errorf("saved index is %d, want 20", state.Indexes["sentinel"])
+	}
+
+	// failed changes are not saved so they are retried after a restart
+	client.Send(&Event{Prefix: "failing", Key: "failing/a", Index: 21})
+	time.Sleep(1 * time.Millisecond)
+	if state, err := LoadState(statePath); err != nil {
+		t.Error(err)
+	} else if index, ok := state.Indexes["failing"]; ok {
+		t.Errorf("saved index of failed change is %d", index)
+	}
+
+	stop <- true
+	<-join
+}
+
+func TestSentinelReload(t *testing.T) {
+	client := &MockClient{}
+	ex1 := &MockExecutor{name: "mock1"}
+	ex2 := &MockExecutor{name: "mock2"}
+	s := Sentinel{Client: client}
+	s.Add([]string{"1", "2"}, ex1)
+	s.Add([]string{"3"}, ex2)
+	stop := make(chan bool)
+	join := make(chan struct{})
+
+	go func() {
+		s.Run(stop)
+		close(join)
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	for _, key := range []string{"1", "2", "3"} {
+		if client.Watches(key) != 1 {
+			t.Errorf("key %s not watched", key)
+		}
+	}
+
+	// mock1 is unchanged, mock2 is removed, and mock3 is added
+	other := Sentinel{Client: client}
+	ex1Copy := &MockExecutor{name: "mock1"}
+	ex3 := &MockExecutor{name: "mock3"}
+	other.Add([]string{"1", "2"}, ex1Copy)
+	other.Add([]string{"2", "4"}, ex3)
+	if err := s.Reload(&other); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Millisecond)
+
+	wantByName := map[string]Executor{"mock1": ex1, "mock3": ex3}
+	wantByKey := map[string][]Executor{
+		"1": {ex1},
+		"2": {ex1, ex3},
+		"4": {ex3},
+	}
+	if !reflect.DeepEqual(wantByName, s.executorsByName) {
+		t.Errorf("%v != %v", wantByName, s.executorsByName)
+	}
+	if !reflect.DeepEqual(wantByKey, s.executorsByKey) {
+		t.Errorf("%v != %v", wantByKey, s.executorsByKey)
+	}
+	if s.executorsByName["mock1"] != Executor(ex1) {
+		t.Error("unchanged executor was replaced")
+	}
+
+	for key, want := range map[string]int{"1": 1, "2": 1, "3": 0, "4": 1} {
+		if have := client.Watches(key); have != want {
+			t.Errorf("key %s has %d watches, want %d", key, have, want)
+		}
+	}
+
+	// a changed executor is replaced
+	ex3Changed := &MockExecutor{name: "mock3", Error: errors.New("changed")}
+	other = Sentinel{Client: client}
+	other.Add([]string{"1", "2"}, ex1Copy)
+	other.Add([]string{"2", "4"}, ex3Changed)
+	if err := s.Reload(&other); err != nil {
+		t.Fatal(err)
+	}
+	if s.executorsByName["mock3"] != Executor(ex3Changed) {
+		t.Error("changed executor was not replaced")
+	}
+
 	stop <- true
 	<-join
+
+	for _, key := range []string{"1", "2", "4"} {
+		if client.Watches(key) != 0 {
+			t.Errorf("key %s still watched", key)
+		}
+	}
+}
+
+func TestSentinelReloadRestart(t *testing.T) {
+	client := &MockClient{}
+	ex := &MockExecutor{name: "mock"}
+	s := Sentinel{Client: client, Elector: NewElector(client, "leader", time.Second)}
+	s.Add([]string{"1"}, ex)
+
+	// a different client is rejected
+	other := Sentinel{Client: &MockClient{}, Elector: NewElector(client, "leader", time.Second)}
+	other.Add([]string{"2"}, &MockExecutor{name: "mock2"})
+	if err := s.Reload(&other); err == nil {
+		t.Error("reload with a new client was accepted")
+	}
+
+	// a different elector is rejected
+	other.Client = client
+	other.Elector = NewElector(client, "other", time.Second)
+	if err := s.Reload(&other); err == nil {
+		t.Error("reload with a new elector was accepted")
+	}
+	if want := map[string]Executor{"mock": ex}; !reflect.DeepEqual(want, s.executorsByName) {
+		t.Errorf("rejected reload changed executors: %v", s.executorsByName)
+	}
+
+	// the same client and elector are accepted
+	other.Elector = NewElector(client, "leader", time.Second)
+	if err := s.Reload(&other); err != nil {
+		t.Error(err)
+	}
+	if _, ok := s.executorsByName["mock2"]; !ok {
+		t.Error("reload did not add executor")
+	}
 }
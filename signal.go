@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The default signal sent to a process.
+const DefaultSignal = "SIGHUP"
+
+var (
+	// The root of the proc filesystem.
+	procRoot = "/proc"
+
+	// The root of the cgroup filesystem.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// Signals which may be sent by name.
+	signalsByName = map[string]syscall.Signal{
+		"SIGHUP":   syscall.SIGHUP,
+		"SIGINT":   syscall.SIGINT,
+		"SIGQUIT":  syscall.SIGQUIT,
+		"SIGKILL":  syscall.SIGKILL,
+		"SIGUSR1":  syscall.SIGUSR1,
+		"SIGUSR2":  syscall.SIGUSR2,
+		"SIGTERM":  syscall.SIGTERM,
+		"SIGCONT":  syscall.SIGCONT,
+		"SIGSTOP":  syscall.SIGSTOP,
+		"SIGWINCH": syscall.SIGWINCH,
+	}
+)
+
+// Configures a signal sent to a process. Exactly one of the targets is set.
+type SignalConfig struct {
+	// The name of the signal, e.g. SIGHUP.
+	Name string
+
+	// The signal to send.
+	Signal syscall.Signal
+
+	// Signal the process whose pid is in this file.
+	Pidfile string
+
+	// Signal every process with this name.
+	Process string
+
+	// Signal every process in this cgroup.
+	Cgroup string
+}
+
+// Parse a signal name or number. The SIG prefix is optional.
+func ParseSignal(name string) (string, syscall.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil && num > 0 {
+		return name, syscall.Signal(num), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if signal, ok := signalsByName[name]; ok {
+		return name, signal, nil
+	}
+	return "", 0, fmt.Errorf("signal %s is invalid", name)
+}
+
+// Describe the target of the signal.
+func (s *SignalConfig) Target() string {
+	switch {
+	case s.Pidfile != "":
+		return "pidfile " + s.Pidfile
+	case s.Process != "":
+		return "process " + s.Process
+	case s.Cgroup != "":
+		return "cgroup " + s.Cgroup
+	}
+	return "nothing"
+}
+
+// Return the pids of the running target processes.
+func (s *SignalConfig) pids() ([]int, error) {
+	var pids []int
+	var err error
+	switch {
+	case s.Pidfile != "":
+		pids, err = pidfilePids(s.Pidfile)
+	case s.Process != "":
+		pids, err = processPids(s.Process)
+	case s.Cgroup != "":
+		pids, err = cgroupPids(s.Cgroup)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	running := make([]int, 0, len(pids))
+	for _, pid := range pids {
+		if pid > 0 && syscall.Kill(pid, 0) != syscall.ESRCH {
+			running = append(running, pid)
+		}
+	}
+	return running, nil
+}
+
+// Send the signal to the running target processes. Return the pids which were
+// signaled. No pids are returned if the target is not running.
+func (s *SignalConfig) Send() ([]int, error) {
+	pids, err := s.pids()
+	if err != nil {
+		return nil, err
+	}
+	sent := make([]int, 0, len(pids))
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, s.Signal); err == syscall.ESRCH {
+			continue
+		} else if err != nil {
+			return sent, fmt.Errorf("failed to send %s to %d: %s", s.Name, pid, err)
+		}
+		sent = append(sent, pid)
+	}
+	return sent, nil
+}
+
+// Read the pid in a pidfile. A missing pidfile means the process is not
+// running.
+func pidfilePids(path string) ([]int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("pidfile %s is invalid", path)
+	}
+	return []int{pid}, nil
+}
+
+// Find the pids of the processes named `name`. The name is matched against
+// the process command name and the base name of its executable.
+func processPids(name string) ([]int, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	comm := name
+	if len(comm) > 15 {
+		// the kernel truncates command names
+		comm = comm[:15]
+	}
+
+	pids := []int{}
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		dir := filepath.Join(procRoot, entry.Name())
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil && strings.TrimSpace(string(data)) == comm {
+			if len(name) <= 15 {
+				pids = append(pids, pid)
+				continue
+			}
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			args := strings.SplitN(string(data), "\x00", 2)
+			if filepath.Base(args[0]) == name {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// Find the pids of the processes in a cgroup. Relative paths are resolved
+// against the cgroup filesystem. A missing cgroup has no processes.
+func cgroupPids(cgroup string) ([]int, error) {
+	if !filepath.IsAbs(cgroup) {
+		cgroup = filepath.Join(cgroupRoot, cgroup)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("cgroup %s has invalid pid %s", cgroup, line)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
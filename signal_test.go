@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := map[string]syscall.Signal{
+		"HUP":     syscall.SIGHUP,
+		"sigusr1": syscall.SIGUSR1,
+		"SIGTERM": syscall.SIGTERM,
+		"9":       syscall.Signal(9),
+	}
+	for name, want := range tests {
+		if _, have, err := ParseSignal(name); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if have != want {
+			t.Errorf("%s: %d != %d", name, have, want)
+		}
+	}
+	if _, _, err := ParseSignal("BOGUS"); err == nil {
+		t.Error("invalid signal parsed")
+	}
+}
+
+func TestSignalTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel-signal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"proc/100/comm":             "haproxy\n",
+		"proc/101/comm":             "nginx\n",
+		"proc/102/comm":             "a-very-long-pro\n",
+		"proc/102/cmdline":          "/usr/bin/a-very-long-process\x00-f\x00",
+		"proc/self/comm":            "haproxy\n",
+		"cgroup/app/cgroup.procs":   "200\n201\n",
+		"cgroup/empty/cgroup.procs": "",
+	}
+	for name, content := range files {
+		file := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procRoot = path.Join(dir, "proc")
+	cgroupRoot = path.Join(dir, "cgroup")
+	defer func() {
+		procRoot = "/proc"
+		cgroupRoot = "/sys/fs/cgroup"
+	}()
+
+	if pids, err := processPids("haproxy"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual([]int{100}, pids) {
+		t.Errorf("process pids %v != [100]", pids)
+	}
+	if pids, err := processPids("a-very-long-process"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual([]int{102}, pids) {
+		t.Errorf("long process pids %v != [102]", pids)
+	}
+	if pids, err := cgroupPids("app"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual([]int{200, 201}, pids) {
+		t.Errorf("cgroup pids %v != [200 201]", pids)
+	}
+	if pids, err := cgroupPids("missing"); err != nil || len(pids) != 0 {
+		t.Errorf("missing cgroup has pids %v: %v", pids, err)
+	}
+}
+
+func TestExecutorSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel-signal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := path.Join(dir, "test.pid")
+
+	name, signal, _ := ParseSignal("CONT")
+	ex := &TemplateExecutor{
+		name:    "signal",
+		Signal:  &SignalConfig{Name: name, Signal: signal, Pidfile: pidfile},
+		Command: []string{"true"},
+	}
+
+	// the command is run if the process is not running
+	if err := ex.Execute(&MockClient{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	status := ex.Status()
+	if status.Signal == nil || status.Signal.Error == "" {
+		t.Error("signal status does not report the missing process")
+	}
+	if status.Command == nil {
+		t.Error("fallback command not run")
+	}
+
+	// the process is signaled if it is running
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	if err := ioutil.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ex.Execute(&MockClient{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	status = ex.Status()
+	if status.Signal == nil || !reflect.DeepEqual([]int{cmd.Process.Pid}, status.Signal.PIDs) {
+		t.Errorf("process was not signaled: %+v", status.Signal)
+	}
+	if status.Command != nil {
+		t.Error("command run after the process was signaled")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// State which is kept across restarts.
+type State struct {
+	// The last processed index of each watched prefix.
+	Indexes map[string]uint64 `json:"indexes"`
+}
+
+// Load the state from the file at `path`. An empty state is returned if the
+// file does not exist.
+func LoadState(path string) (*State, error) {
+	state := &State{Indexes: map[string]uint64{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("state file %s is invalid: %s", path, err)
+	}
+	if state.Indexes == nil {
+		state.Indexes = map[string]uint64{}
+	}
+	return state, nil
+}
+
+// Save the state to the file at `path`. The file is replaced atomically so
+// that a crash never leaves a partial state behind.
+func (s *State) Save(path string) (err error) {
+	var data []byte
+	if data, err = json.Marshal(s); err != nil {
+		return
+	}
+
+	var tmp *os.File
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	if tmp, err = ioutil.TempFile(dir, fmt.Sprintf(".%s-", name)); err != nil {
+		return
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), path)
+	return
+}
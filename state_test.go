@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentinel-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := path.Join(dir, "state.json")
+
+	// a missing file is an empty state
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Indexes) != 0 {
+		t.Errorf("state is not empty: %v", state.Indexes)
+	}
+
+	state.Indexes["sentinel/a"] = 12
+	state.Indexes["sentinel/b"] = 34
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, loaded) {
+		t.Errorf("%v != %v", state, loaded)
+	}
+
+	// an invalid file is an error
+	if err := ioutil.WriteFile(statePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(statePath); err == nil {
+		t.Error("invalid state loaded")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+// The result of rendering a template.
+type TemplateStatus struct {
+	Src     string `json:"src"`
+	Dest    string `json:"dest"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// The result of running a command.
+type CommandStatus struct {
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exit_code"`
+	Output   string   `json:"output"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// The result of signaling a process.
+type SignalStatus struct {
+	Signal string `json:"signal"`
+	Target string `json:"target"`
+	PIDs   []int  `json:"pids"`
+	Error  string `json:"error,omitempty"`
+}
+
+// The result of writing a key.
+type KVStatus struct {
+	Key     string `json:"key"`
+	Changed bool   `json:"changed"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// The result of calling a webhook.
+type WebhookStatus struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error,omitempty"`
+}
+
+// The result of an executor run.
+type ExecutionStatus struct {
+	Time      time.Time        `json:"time"`
+	Duration  float64          `json:"duration"`
+	Templates []TemplateStatus `json:"templates"`
+	Signal    *SignalStatus    `json:"signal,omitempty"`
+	Command   *CommandStatus   `json:"command,omitempty"`
+	Webhook   *WebhookStatus   `json:"webhook,omitempty"`
+	KV        *KVStatus        `json:"kv,omitempty"`
+	Skipped   string           `json:"skipped,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// An executor which reports the result of its last run.
+type StatusReporter interface {
+	// Return the status of the last run or nil if the executor has not run.
+	Status() *ExecutionStatus
+}
+
+// The status of a watched key.
+type WatchStatus struct {
+	Key string `json:"key"`
+
+	// The index of the last change on the key which was handled successfully.
+	Index uint64 `json:"index"`
+}
+
+// The status of a watcher.
+type WatcherStatus struct {
+	Name          string           `json:"name"`
+	Watch         []WatchStatus    `json:"watch"`
+	LastTrigger   *time.Time       `json:"last_trigger,omitempty"`
+	LastKey       string           `json:"last_key,omitempty"`
+	LastExecution *ExecutionStatus `json:"last_execution,omitempty"`
+}
+
+// The last event to trigger a watcher.
+type trigger struct {
+	time  time.Time
+	event *Event
+}
+
+// Sort watcher statuses by name.
+type watcherStatusByName []WatcherStatus
+
+func (s watcherStatusByName) Len() int           { return len(s) }
+func (s watcherStatusByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s watcherStatusByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"gopkg.in/BlueDragonX/go-hash.v1"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -10,29 +10,106 @@ import (
 	"text/template"
 )
 
+// Return the functions available to templates.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"replace":     strings.Replace,
+		"addrHost":    AddrHost,
+		"addrPort":    AddrPort,
+		"urlScheme":   URLScheme,
+		"urlUsername": URLUsername,
+		"urlPassword": URLPassword,
+		"urlHost":     URLHost,
+		"urlPath":     URLPath,
+		"urlRawQuery": URLRawQuery,
+		"urlQuery":    URLQuery,
+		"urlFragment": URLFragment,
+		"json":        JSON,
+		"shellQuote":  ShellQuote,
+	}
+}
+
+// The data command arguments are rendered with.
+type CommandData struct {
+	// The name of the watcher.
+	Watcher string
+
+	// The context the templates were rendered with.
+	Context interface{}
+
+	// The change which triggered the run. Its fields are empty if the run
+	// was not triggered by a change.
+	Event *Event
+
+	// The template destinations which changed.
+	Changed []string
+}
+
+// Parse a command argument as a template.
+func parseCommandArg(arg string) (*template.Template, error) {
+	return template.New("command").Funcs(TemplateFuncs()).Parse(arg)
+}
+
+// Render each of the command `args` as a template with `data`.
+func RenderCommand(args []string, data *CommandData) ([]string, error) {
+	rendered := make([]string, len(args))
+	for n, arg := range args {
+		tpl, err := parseCommandArg(arg)
+		if err != nil {
+			return nil, fmt.Errorf("command argument %d is invalid: %s", n, err)
+		}
+		out := &bytes.Buffer{}
+		if err := tpl.Execute(out, data); err != nil {
+			return nil, fmt.Errorf("command argument %d failed: %s", n, err)
+		}
+		rendered[n] = out.String()
+	}
+	return rendered, nil
+}
+
 // Describes a template as part of a watcher.
 type Template struct {
 	Src  string
 	Dest string
 }
 
-// Return true if one file differs from another.
-func (t *Template) differs(fileA, fileB string) bool {
-	var err error
-	var hashA, hashB string
-	if hashA, err = hash.File(fileA); err != nil {
-		logger.Debugf("unable to hash file %s", fileA)
-		return true
+// Render the template with `context` and return the result.
+func (t *Template) render(context interface{}) ([]byte, error) {
+	name := filepath.Base(t.Src)
+	tpl, err := template.New(name).Funcs(TemplateFuncs()).ParseFiles(t.Src)
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	if err = tpl.Execute(out, context); err != nil {
+		return nil, err
 	}
-	if hashB, err = hash.File(fileB); err != nil {
-		logger.Debugf("unable to hash file %s", fileB)
+	return out.Bytes(), nil
+}
+
+// Return the current contents of the destination or nil if it does not
+// exist.
+func (t *Template) current() []byte {
+	content, err := ioutil.ReadFile(t.Dest)
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// Return true if `content` differs from the current contents of the
+// destination.
+func (t *Template) compare(content []byte) bool {
+	current, err := ioutil.ReadFile(t.Dest)
+	if err != nil {
 		return true
 	}
-	return hashA != hashB
+	return !bytes.Equal(current, content)
 }
 
-// Render the template to a temporary and return true if the original was changed.
-func (t *Template) Render(context interface{}) (changed bool, err error) {
+// Atomically replace the destination with `content`. The content is written
+// to a temporary file which is then renamed over the destination.
+func (t *Template) install(content []byte) (err error) {
 	// create the destination directory
 	dir := filepath.Dir(t.Dest)
 	if err = os.MkdirAll(dir, 0777); err != nil {
@@ -47,41 +124,15 @@ func (t *Template) Render(context interface{}) (changed bool, err error) {
 	}
 	defer func() {
 		tmp.Close()
-		if !changed || err != nil {
+		if err != nil {
 			os.Remove(tmp.Name())
 		}
 	}()
 
-	// add functions to the templates
-	funcs := template.FuncMap{
-		"replace":     strings.Replace,
-		"addrHost":    AddrHost,
-		"addrPort":    AddrPort,
-		"urlScheme":   URLScheme,
-		"urlUsername": URLUsername,
-		"urlPassword": URLPassword,
-		"urlHost":     URLHost,
-		"urlPath":     URLPath,
-		"urlRawQuery": URLRawQuery,
-		"urlQuery":    URLQuery,
-		"urlFragment": URLFragment,
-		"json":        JSON,
-	}
-
-	// render the template to the temp file
-	var tpl *template.Template
-	name := filepath.Base(t.Src)
-	if tpl, err = template.New(name).Funcs(funcs).ParseFiles(t.Src); err != nil {
-		return
-	}
-	if err = tpl.Execute(tmp, context); err != nil {
+	if _, err = tmp.Write(content); err != nil {
 		return
 	}
-	tmp.Close()
-
-	// return if the old and new files are the same
-	changed = t.differs(t.Dest, tmp.Name())
-	if !changed {
+	if err = tmp.Close(); err != nil {
 		return
 	}
 
@@ -89,3 +140,16 @@ func (t *Template) Render(context interface{}) (changed bool, err error) {
 	err = os.Rename(tmp.Name(), t.Dest)
 	return
 }
+
+// Render the template and install it if it differs from the destination.
+// Return true if the destination was changed.
+func (t *Template) Render(context interface{}) (changed bool, err error) {
+	var content []byte
+	if content, err = t.render(context); err != nil {
+		return
+	}
+	if changed = t.compare(content); changed {
+		err = t.install(content)
+	}
+	return
+}
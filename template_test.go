@@ -8,43 +8,28 @@ import (
 	"testing"
 )
 
-func TestTemplateDiffers(t *testing.T) {
+func TestTemplateCompare(t *testing.T) {
 	dir, err := ioutil.TempDir("", "sentinel_test_")
 	if err != nil {
 		t.Fatal("failed to create tempdir")
 	}
 	defer os.RemoveAll(dir)
-	tpl := Template{}
+	tpl := Template{Dest: path.Join(dir, "dest")}
 
-	// only one file exists
-	nameA := path.Join(dir, "only_one_a")
-	nameB := path.Join(dir, "only_one_b")
-	ioutil.WriteFile(nameA, []byte("example"), 0600)
-	if !tpl.differs(nameA, nameB) {
-		t.Error("differs returns incorrect value on missing file")
+	// destination does not exist
+	if !tpl.compare([]byte("example")) {
+		t.Error("compare returns incorrect value on missing file")
 	}
 
-	// files are the same
-	nameA = path.Join(dir, "same_a")
-	nameB = path.Join(dir, "same_b")
-	value := []byte("example")
-	ioutil.WriteFile(nameA, value, 0600)
-	ioutil.WriteFile(nameB, value, 0600)
-	if tpl.differs(nameA, nameB) {
-		t.Error("differs returns incorrect value on identical files")
-		fileA, _ := ioutil.ReadFile(nameA)
-		fileB, _ := ioutil.ReadFile(nameB)
-		t.Errorf("file a: %s", fileA)
-		t.Errorf("file b: %s", fileB)
+	// contents are the same
+	ioutil.WriteFile(tpl.Dest, []byte("example"), 0600)
+	if tpl.compare([]byte("example")) {
+		t.Error("compare returns incorrect value on identical contents")
 	}
 
-	// flies are different
-	nameA = path.Join(dir, "same_a")
-	nameB = path.Join(dir, "same_b")
-	ioutil.WriteFile(nameA, []byte("example_a"), 0600)
-	ioutil.WriteFile(nameB, []byte("example_b"), 0600)
-	if !tpl.differs(nameA, nameB) {
-		t.Error("differs returns incorrect value on differing files")
+	// contents are different
+	if !tpl.compare([]byte("example_b")) {
+		t.Error("compare returns incorrect value on differing contents")
 	}
 }
 
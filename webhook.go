@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+const (
+	// The default time to wait for a webhook to respond.
+	DefaultWebhookTimeout = 10 * time.Second
+
+	// The default time to wait before the first retry of a failed webhook.
+	// The delay doubles with each retry.
+	DefaultWebhookRetryDelay = time.Second
+
+	// The header which holds the signature of a signed webhook.
+	WebhookSignatureHeader = "X-Sentinel-Signature"
+)
+
+// Configures a webhook.
+type Webhook struct {
+	// The URL to POST to.
+	URL string
+
+	// Additional headers to send.
+	Headers map[string]string
+
+	// Sign the payload with HMAC-SHA256 using this secret if it is set.
+	Secret string
+
+	// How long to wait for a response.
+	Timeout time.Duration
+
+	// How many times a failed call is retried.
+	Retries int
+
+	// How long to wait before the first retry.
+	RetryDelay time.Duration
+
+	// Include the context in the payload.
+	Context bool
+}
+
+// The payload posted to a webhook.
+type WebhookPayload struct {
+	Watcher string      `json:"watcher"`
+	Time    time.Time   `json:"time"`
+	Event   *Event      `json:"event,omitempty"`
+	Changed []string    `json:"changed"`
+	Context interface{} `json:"context,omitempty"`
+}
+
+// Return the signature of `body` as sent in the signature header.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post `body` to the webhook once. Return the status code of the response.
+func (w *Webhook) post(body []byte) (int, error) {
+	request, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		request.Header.Set(name, value)
+	}
+	if w.Secret != "" {
+		request.Header.Set(WebhookSignatureHeader, WebhookSignature(w.Secret, body))
+	}
+
+	client := &http.Client{Timeout: w.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Post `body` to the webhook. Failed calls are retried with an increasing
+// delay unless the server rejected the payload. Retries are abandoned when
+// `stop` is closed. The result is stored in `status`.
+func (w *Webhook) Post(body []byte, status *WebhookStatus, stop <-chan bool) error {
+	delay := w.RetryDelay
+	for {
+		status.Attempts++
+		code, err := w.post(body)
+		status.StatusCode = code
+		if err == nil {
+			status.Error = ""
+			return nil
+		}
+		status.Error = err.Error()
+		retryable := code == 0 || code == http.StatusTooManyRequests || code >= 500
+		if !retryable || status.Attempts > w.Retries {
+			return err
+		}
+		logger.Debugf("webhook %s failed, retrying in %s: %s", w.URL, delay, err)
+		select {
+		case <-stop:
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// An executor which renders templates and then posts to a webhook. The
+// webhook is called if one of the template destinations changes or no
+// templates are present.
+type WebhookExecutor struct {
+	*TemplateExecutor
+	Webhook *Webhook
+}
+
+// Post the payload of a run to the webhook. Retries are abandoned when `stop`
+// is closed. The result is stored in `status`.
+func (ex *WebhookExecutor) call(client Client, event *Event, context interface{}, status *ExecutionStatus, stop <-chan bool) error {
+	payload := &WebhookPayload{
+		Watcher: ex.name,
+		Time:    status.Time,
+		Event:   event,
+		Changed: []string{},
+	}
+	for _, tpl := range status.Templates {
+		if tpl.Changed {
+			payload.Changed = append(payload.Changed, tpl.Dest)
+		}
+	}
+	if ex.Webhook.Context {
+		payload.Context = context
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	status.Webhook = &WebhookStatus{URL: ex.Webhook.URL}
+	hookLog := ex.log().With(Fields{"url": ex.Webhook.URL})
+	if err := ex.Webhook.Post(body, status.Webhook, stop); err != nil {
+		hookLog.With(Fields{"attempts": status.Webhook.Attempts}).Errorf("webhook failed: %s", err)
+		return err
+	}
+	hookLog.Debugf("webhook called")
+	return nil
+}
+
+// Render the templates using the context retrieved from the provided `client`
+// and call the webhook if they changed. The execution is written to the audit
+// log if one is configured.
+func (ex *WebhookExecutor) Execute(client Client, event *Event) error {
+	return ex.ExecuteStop(client, event, nil)
+}
+
+// Execute as Execute does. Retries of the webhook are abandoned when `stop` is
+// closed.
+func (ex *WebhookExecutor) ExecuteStop(client Client, event *Event, stop <-chan bool) error {
+	return ex.executeAction(client, event, func(client Client, event *Event, context interface{}, status *ExecutionStatus) error {
+		return ex.call(client, event, context, status, stop)
+	})
+}
+
+// Show the changes to the templates and whether the webhook would be called.
+// Write the changes to `out`.
+func (ex *WebhookExecutor) DryRun(client Client, out io.Writer) error {
+	changed, err := ex.dryRender(client, out)
+	if err != nil {
+		return err
+	}
+	if changed {
+		_, err = fmt.Fprintf(out, "webhook would be called: %s\n", ex.Webhook.URL)
+	} else {
+		_, err = fmt.Fprintf(out, "webhook would not be called: %s\n", ex.Webhook.URL)
+	}
+	return err
+}
+
+// Return true if two executors are configured the same.
+func (ex *WebhookExecutor) Equal(other Executor) bool {
+	otherEx, ok := other.(*WebhookExecutor)
+	if !ok {
+		return false
+	}
+	return ex.TemplateExecutor.Equal(otherEx.TemplateExecutor) && reflect.DeepEqual(ex.Webhook, otherEx.Webhook)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhook server which fails the first `failures` requests with `code`.
+type mockWebhookServer struct {
+	failures int
+	code     int
+	lock     sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (m *mockWebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	m.requests = append(m.requests, r)
+	m.bodies = append(m.bodies, body)
+	if len(m.requests) <= m.failures {
+		w.WriteHeader(m.code)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhookExecutor(t *testing.T) {
+	mock := &mockWebhookServer{failures: 1, code: http.StatusServiceUnavailable}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	client := &MockClient{GetValue: map[string]interface{}{"app": map[string]interface{}{"port": "80"}}}
+	ex := &WebhookExecutor{
+		TemplateExecutor: &TemplateExecutor{name: "hook", prefix: "app", context: []string{"app/port"}},
+		Webhook: &Webhook{
+			URL:        server.URL,
+			Headers:    map[string]string{"X-Test": "yes"},
+			Secret:     "shh",
+			Timeout:    time.Second,
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+			Context:    true,
+		},
+	}
+	event := &Event{Prefix: "app", Key: "app/port", Action: "set", Index: 5}
+	if err := ex.Execute(client, event); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mock.requests) != 2 {
+		t.Fatalf("webhook called %d times, want 2", len(mock.requests))
+	}
+	request, body := mock.requests[1], mock.bodies[1]
+	if have := request.Header.Get("X-Test"); have != "yes" {
+		t.Errorf("header X-Test is '%s', want 'yes'", have)
+	}
+	if have, want := request.Header.Get(WebhookSignatureHeader), WebhookSignature("shh", body); have != want {
+		t.Errorf("signature %s != %s", have, want)
+	}
+
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	wantEvent := map[string]interface{}{"prefix": "app", "key": "app/port", "action": "set", "index": 5.0}
+	if payload["watcher"] != "hook" {
+		t.Errorf("payload watcher is %v", payload["watcher"])
+	}
+	if !reflect.DeepEqual(wantEvent, payload["event"]) {
+		t.Errorf("%v != %v", wantEvent, payload["event"])
+	}
+	if !reflect.DeepEqual(map[string]interface{}{"port": "80"}, payload["context"]) {
+		t.Errorf("payload context is %v", payload["context"])
+	}
+
+	status := ex.Status()
+	if status == nil || status.Webhook == nil {
+		t.Fatal("webhook status missing")
+	}
+	wantStatus := WebhookStatus{URL: server.URL, StatusCode: http.StatusNoContent, Attempts: 2}
+	if !reflect.DeepEqual(wantStatus, *status.Webhook) {
+		t.Errorf("%+v != %+v", wantStatus, *status.Webhook)
+	}
+}
+
+func TestWebhookRejected(t *testing.T) {
+	mock := &mockWebhookServer{failures: 10, code: http.StatusBadRequest}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL, Timeout: time.Second, Retries: 3, RetryDelay: time.Millisecond}
+	status := &WebhookStatus{}
+	if err := hook.Post([]byte("{}"), status, nil); err == nil {
+		t.Error("rejected webhook succeeded")
+	}
+	if status.Attempts != 1 {
+		t.Errorf("rejected webhook attempted %d times, want 1", status.Attempts)
+	}
+	if status.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code is %d, want %d", status.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestWebhookStopped(t *testing.T) {
+	mock := &mockWebhookServer{failures: 10, code: http.StatusServiceUnavailable}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL, Timeout: time.Second, Retries: 3, RetryDelay: time.Hour}
+	status := &WebhookStatus{}
+	stop := make(chan bool)
+	close(stop)
+	if err := hook.Post([]byte("{}"), status, stop); err == nil {
+		t.Error("stopped webhook succeeded")
+	}
+	if status.Attempts != 1 {
+		t.Errorf("stopped webhook attempted %d times, want 1", status.Attempts)
+	}
+}